@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+func init() {
+	RegisterMergePlanner("leveled", func(o MergePlannerOptions) MergePlanner { return newLeveledMergePlanner(o) })
+}
+
+// leveledFanout is the default fan-out k used by the leveled merge planner:
+// a part with rowsCount rows is assigned level floor(log_k(rowsCount)),
+// mirroring LevelDB's size-tiered level assignment.
+const leveledFanout = 10
+
+// leveledBytesPerRow is a rough, constant estimate of on-disk bytes per row,
+// used only to turn a part's RowsCount into a byte budget for scheduling.
+// This snapshot's partHeader doesn't track a part's actual compressed size,
+// so the planner works off this heuristic rather than an exact figure.
+const leveledBytesPerRow = 8
+
+// leveledMaxBytesPerLevel0 is the byte budget of level 0 before it's
+// scheduled for merging; level L's budget is leveledMaxBytesPerLevel0 *
+// fanout^L, following LevelDB's exponential per-level growth.
+const leveledMaxBytesPerLevel0 = 16 * 1024 * 1024
+
+// leveledMergePlanner is a cost-based, leveled-compaction MergePlanner
+// modeled after LevelDB's size-tiered strategy: it replaces the exhaustive
+// appendPartsToMerge search (which costs O(n^2*maxPartsToMerge) per call)
+// with an O(n) bucketing of parts into levels by rowsCount, merging a level
+// once it holds too many parts or exceeds its byte budget.
+type leveledMergePlanner struct {
+	fanout int
+
+	mu     sync.Mutex
+	levels map[MergeTier]map[int]levelStats
+}
+
+// levelStats snapshots the last Plan() call's view of one level, for
+// operator visibility into per-level backlog and write amplification.
+type levelStats struct {
+	partsCount        int
+	pendingBytes      uint64
+	estimatedWriteAmp float64
+}
+
+func newLeveledMergePlanner(o MergePlannerOptions) *leveledMergePlanner {
+	fanout := leveledFanout
+	if o.TierGrowthFactor > 1 {
+		fanout = int(o.TierGrowthFactor)
+	}
+	return &leveledMergePlanner{
+		fanout: fanout,
+		levels: make(map[MergeTier]map[int]levelStats),
+	}
+}
+
+func (mp *leveledMergePlanner) Plan(smallParts, bigParts []*partWrapper, isFinal bool, maxOutputRows uint64) []MergeTask {
+	var tasks []MergeTask
+	if t, ok := mp.planTier(MergeTierSmall, smallParts, isFinal, maxOutputRows); ok {
+		tasks = append(tasks, t)
+	}
+	if t, ok := mp.planTier(MergeTierBig, bigParts, isFinal, maxOutputRows); ok {
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// planTier assigns every non-merging part in pws to a level (levelOf), then
+// picks the lowest level whose part count or byte budget (levelBudgetBytes)
+// is exceeded, and merges it together with any overlapping parts (by time
+// range) from level+1, so the merge also absorbs the level it would
+// otherwise have to immediately re-merge into. The combined parts are capped
+// to maxOutputRows (see capPartsByRowBudget) so the merge output fits on disk.
+func (mp *leveledMergePlanner) planTier(tier MergeTier, pws []*partWrapper, isFinal bool, maxOutputRows uint64) (MergeTask, bool) {
+	byLevel := make(map[int][]*partWrapper)
+	for _, pw := range pws {
+		if pw.isInMerge {
+			continue
+		}
+		byLevel[mp.levelOf(pw)] = append(byLevel[mp.levelOf(pw)], pw)
+	}
+
+	stats := make(map[int]levelStats, len(byLevel))
+	var task MergeTask
+	found := false
+	minTriggeredLevel := math.MaxInt32
+
+	for level, members := range byLevel {
+		levelBytes := bytesOfParts(members)
+		stats[level] = levelStats{
+			partsCount:   len(members),
+			pendingBytes: levelBytes,
+		}
+
+		triggered := len(members) > mp.fanout || levelBytes > mp.levelBudgetBytes(level)
+		if isFinal {
+			triggered = triggered || len(members) >= finalPartsToMerge
+		}
+		if !triggered || level >= minTriggeredLevel {
+			continue
+		}
+
+		parts := append([]*partWrapper(nil), members...)
+		parts = append(parts, overlappingParts(byLevel[level+1], timeRangeOfParts(members))...)
+		parts = capPartsByRowBudget(parts, maxOutputRows)
+		if len(parts) < 2 {
+			continue
+		}
+
+		inputBytes := bytesOfParts(parts)
+		st := stats[level]
+		st.estimatedWriteAmp = float64(inputBytes) / float64(levelBytes)
+		stats[level] = st
+
+		minTriggeredLevel = level
+		task = MergeTask{Parts: parts, Tier: tier}
+		found = true
+	}
+
+	mp.mu.Lock()
+	mp.levels[tier] = stats
+	mp.mu.Unlock()
+
+	return task, found
+}
+
+// levelOf assigns pw to level floor(log_fanout(rowsCount)), treating parts
+// with at most fanout rows as level 0.
+func (mp *leveledMergePlanner) levelOf(pw *partWrapper) int {
+	rows := pw.p.ph.RowsCount
+	fanout := uint64(mp.fanout)
+	if rows <= fanout {
+		return 0
+	}
+	level := 0
+	for fanout > 1 && rows >= fanout {
+		rows /= fanout
+		level++
+	}
+	return level
+}
+
+// levelBudgetBytes is the byte budget of level, growing exponentially by
+// mp.fanout per level so higher levels absorb proportionally more data
+// before they're merged down again.
+func (mp *leveledMergePlanner) levelBudgetBytes(level int) uint64 {
+	return leveledMaxBytesPerLevel0 * uint64(math.Pow(float64(mp.fanout), float64(level)))
+}
+
+// LevelMetrics returns a point-in-time snapshot of per-level part counts,
+// pending compaction bytes and estimated write amplification computed by
+// the most recent Plan() call, for operators to inspect backlog per level
+// instead of a single opaque active-merges gauge.
+func (mp *leveledMergePlanner) LevelMetrics(tier MergeTier) map[int]levelStats {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	out := make(map[int]levelStats, len(mp.levels[tier]))
+	for level, st := range mp.levels[tier] {
+		out[level] = st
+	}
+	return out
+}
+
+// AppendLevelMergeStats appends mp's LevelMetrics for tier to dst in
+// ascending level order as the exported LevelMergeStats type, so
+// partition.UpdateMetrics can expose them via partitionMetrics without
+// reaching into leveledMergePlanner's unexported levelStats directly. It
+// implements levelMetricsProvider.
+func (mp *leveledMergePlanner) AppendLevelMergeStats(dst []LevelMergeStats, tier MergeTier) []LevelMergeStats {
+	stats := mp.LevelMetrics(tier)
+	levels := make([]int, 0, len(stats))
+	for level := range stats {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+	for _, level := range levels {
+		st := stats[level]
+		dst = append(dst, LevelMergeStats{
+			Level:             level,
+			PartsCount:        st.partsCount,
+			PendingBytes:      st.pendingBytes,
+			EstimatedWriteAmp: st.estimatedWriteAmp,
+		})
+	}
+	return dst
+}
+
+func bytesOfParts(pws []*partWrapper) uint64 {
+	n := uint64(0)
+	for _, pw := range pws {
+		n += pw.p.ph.RowsCount * leveledBytesPerRow
+	}
+	return n
+}
+
+func timeRangeOfParts(pws []*partWrapper) TimeRange {
+	var tr TimeRange
+	for i, pw := range pws {
+		if i == 0 || pw.p.ph.MinTimestamp < tr.MinTimestamp {
+			tr.MinTimestamp = pw.p.ph.MinTimestamp
+		}
+		if i == 0 || pw.p.ph.MaxTimestamp > tr.MaxTimestamp {
+			tr.MaxTimestamp = pw.p.ph.MaxTimestamp
+		}
+	}
+	return tr
+}
+
+// overlappingParts returns the members of pws whose time range intersects tr.
+func overlappingParts(pws []*partWrapper, tr TimeRange) []*partWrapper {
+	var out []*partWrapper
+	for _, pw := range pws {
+		if pw.isInMerge {
+			continue
+		}
+		if pw.p.ph.MinTimestamp > tr.MaxTimestamp || pw.p.ph.MaxTimestamp < tr.MinTimestamp {
+			continue
+		}
+		out = append(out, pw)
+	}
+	return out
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// MergeTier identifies which on-disk tier a merge task targets.
+type MergeTier int
+
+const (
+	// MergeTierSmall targets smallParts.
+	MergeTierSmall MergeTier = iota
+	// MergeTierBig targets bigParts.
+	MergeTierBig
+)
+
+// MergeTask describes a single planned merge.
+type MergeTask struct {
+	// Parts is the set of input parts to merge together.
+	Parts []*partWrapper
+
+	// Tier is the destination tier for the merged output.
+	Tier MergeTier
+}
+
+// MergePlanner decides which parts should be merged together.
+//
+// The default implementation (newTieredMergePlanner) is modeled after
+// bleve's scorch merge planner: parts are grouped into size tiers and a tier
+// is merged once it accumulates too many segments for its floor size.
+// Callers may register a custom MergePlanner (e.g. a leveled-LSM planner) via
+// RegisterMergePlanner to trade off write amplification against read
+// amplification without a rebuild.
+type MergePlanner interface {
+	// Plan returns the list of merge tasks to run given the current
+	// contents of the small and big tiers. isFinal requests a more
+	// aggressive plan suitable for use when the partition is otherwise idle.
+	// maxOutputRows bounds the rows count of every returned task's output
+	// part (0 means unbounded), so a plan never selects more data than fits
+	// on disk; see partition.maxOutPartRows.
+	Plan(smallParts, bigParts []*partWrapper, isFinal bool, maxOutputRows uint64) []MergeTask
+}
+
+var (
+	defaultPlannerMu   sync.Mutex
+	registeredPlanners = map[string]func(MergePlannerOptions) MergePlanner{
+		"tiered": func(o MergePlannerOptions) MergePlanner { return newTieredMergePlanner(o) },
+	}
+)
+
+// RegisterMergePlanner registers a named MergePlanner constructor, so it can
+// be selected via MergePlannerOptions.Kind.
+//
+// This is typically called from an init() function at startup.
+func RegisterMergePlanner(name string, newPlanner func(MergePlannerOptions) MergePlanner) {
+	defaultPlannerMu.Lock()
+	defer defaultPlannerMu.Unlock()
+	registeredPlanners[name] = newPlanner
+}
+
+// MergePlannerOptions configures the default tiered MergePlanner.
+//
+// Options are analogous to bleve's scorch merge planner knobs, and may be
+// loaded from a JSON file next to the partition via
+// LoadMergePlannerOptions, so operators can tune write-amp vs. read-amp
+// without a rebuild.
+type MergePlannerOptions struct {
+	// Kind selects a registered MergePlanner implementation. Empty means "tiered".
+	Kind string `json:"kind,omitempty"`
+
+	// FloorSegmentSize is the smallest segment (part) size, in rows, that
+	// participates in tiering. Parts smaller than this are treated as if
+	// they had this size, so a flood of tiny parts doesn't spawn extra tiers.
+	FloorSegmentSize uint64 `json:"floorSegmentSize"`
+
+	// MaxSegmentsPerTier is how many segments a tier may hold before it is
+	// scheduled for merging.
+	MaxSegmentsPerTier int `json:"maxSegmentsPerTier"`
+
+	// TierGrowthFactor is the size multiple between adjacent tiers.
+	TierGrowthFactor float64 `json:"tierGrowthFactor"`
+
+	// SegmentsPerMergeTask caps how many segments a single merge task combines.
+	SegmentsPerMergeTask int `json:"segmentsPerMergeTask"`
+}
+
+// defaultMergePlannerOptions is derived from the previous hard-coded
+// constants (defaultPartsToMerge, maxRowsPerSmallPart). The tiered grouping
+// that uses them differs from the appendPartsToMerge search, so the parts
+// picked for a merge can differ from before.
+var defaultMergePlannerOptions = MergePlannerOptions{
+	Kind:                 "tiered",
+	FloorSegmentSize:      maxRowsPerSmallPart / defaultPartsToMerge,
+	MaxSegmentsPerTier:    defaultPartsToMerge,
+	TierGrowthFactor:      4,
+	SegmentsPerMergeTask:  defaultPartsToMerge,
+}
+
+// LoadMergePlannerOptions loads MergePlannerOptions from the JSON file at
+// path. If the file doesn't exist, defaultMergePlannerOptions is returned.
+func LoadMergePlannerOptions(path string) (MergePlannerOptions, error) {
+	o := defaultMergePlannerOptions
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return o, nil
+		}
+		return o, fmt.Errorf("cannot read merge planner options from %q: %s", path, err)
+	}
+	if err := json.Unmarshal(data, &o); err != nil {
+		return o, fmt.Errorf("cannot parse merge planner options from %q: %s", path, err)
+	}
+	return o, nil
+}
+
+// NewMergePlanner constructs the MergePlanner identified by o.Kind, falling
+// back to the tiered planner if o.Kind is empty or unknown.
+func NewMergePlanner(o MergePlannerOptions) MergePlanner {
+	defaultPlannerMu.Lock()
+	newPlanner, ok := registeredPlanners[o.Kind]
+	defaultPlannerMu.Unlock()
+	if !ok {
+		newPlanner = registeredPlanners["tiered"]
+	}
+	return newPlanner(o)
+}
+
+type tieredMergePlanner struct {
+	o MergePlannerOptions
+}
+
+func newTieredMergePlanner(o MergePlannerOptions) *tieredMergePlanner {
+	if o.FloorSegmentSize <= 0 {
+		o.FloorSegmentSize = defaultMergePlannerOptions.FloorSegmentSize
+	}
+	if o.MaxSegmentsPerTier <= 0 {
+		o.MaxSegmentsPerTier = defaultMergePlannerOptions.MaxSegmentsPerTier
+	}
+	if o.TierGrowthFactor <= 1 {
+		o.TierGrowthFactor = defaultMergePlannerOptions.TierGrowthFactor
+	}
+	if o.SegmentsPerMergeTask <= 0 {
+		o.SegmentsPerMergeTask = defaultMergePlannerOptions.SegmentsPerMergeTask
+	}
+	return &tieredMergePlanner{o: o}
+}
+
+func (mp *tieredMergePlanner) Plan(smallParts, bigParts []*partWrapper, isFinal bool, maxOutputRows uint64) []MergeTask {
+	var tasks []MergeTask
+	if t, ok := mp.planTier(smallParts, isFinal, maxOutputRows); ok {
+		t.Tier = MergeTierSmall
+		tasks = append(tasks, t)
+	}
+	if t, ok := mp.planTier(bigParts, isFinal, maxOutputRows); ok {
+		t.Tier = MergeTierBig
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// planTier groups pws into size tiers (tierOf) and, once a tier's budget
+// (calcBudget, lowered to finalPartsToMerge-1 when isFinal is set) is
+// exceeded, returns a task merging up to
+// SegmentsPerMergeTask of its smallest members, capped to maxOutputRows so
+// the merge output fits on disk (see capPartsByRowBudget).
+func (mp *tieredMergePlanner) planTier(pws []*partWrapper, isFinal bool, maxOutputRows uint64) (MergeTask, bool) {
+	segmentsPerMergeTask := mp.o.SegmentsPerMergeTask
+	if isFinal {
+		// Lower the task size toward finalPartsToMerge, so idle time is used
+		// to consolidate into fewer, bigger parts for better select performance.
+		segmentsPerMergeTask = finalPartsToMerge
+	}
+
+	byTier := make(map[int][]*partWrapper)
+	for _, pw := range pws {
+		if pw.isInMerge {
+			continue
+		}
+		byTier[mp.tierOf(pw)] = append(byTier[mp.tierOf(pw)], pw)
+	}
+
+	for tier, members := range byTier {
+		budget := mp.calcBudget(tier)
+		if isFinal && budget >= finalPartsToMerge {
+			budget = finalPartsToMerge - 1
+		}
+		if len(members) <= budget {
+			continue
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].p.ph.RowsCount < members[j].p.ph.RowsCount
+		})
+		n := segmentsPerMergeTask
+		if n > len(members) {
+			n = len(members)
+		}
+		if n < 2 {
+			continue
+		}
+		parts := capPartsByRowBudget(members[:n], maxOutputRows)
+		if len(parts) < 2 {
+			continue
+		}
+		return MergeTask{Parts: parts}, true
+	}
+	return MergeTask{}, false
+}
+
+// capPartsByRowBudget trims parts to a prefix whose RowsCount sums to at
+// most maxOutputRows (0 means unbounded), so a planned merge never produces
+// an output part that can't fit in the free disk space budgeted for it. It
+// returns nil if fewer than 2 parts fit, since merging a single part is
+// pointless.
+func capPartsByRowBudget(parts []*partWrapper, maxOutputRows uint64) []*partWrapper {
+	if maxOutputRows == 0 {
+		return parts
+	}
+	var rowsSum uint64
+	out := make([]*partWrapper, 0, len(parts))
+	for _, pw := range parts {
+		rows := pw.p.ph.RowsCount
+		if rowsSum+rows > maxOutputRows {
+			break
+		}
+		rowsSum += rows
+		out = append(out, pw)
+	}
+	if len(out) < 2 {
+		return nil
+	}
+	return out
+}
+
+// tierOf assigns pw to a size tier based on its rows count relative to
+// FloorSegmentSize and TierGrowthFactor.
+func (mp *tieredMergePlanner) tierOf(pw *partWrapper) int {
+	rows := float64(pw.p.ph.RowsCount)
+	floor := float64(mp.o.FloorSegmentSize)
+	if rows <= floor {
+		return 0
+	}
+	return int(math.Log(rows/floor) / math.Log(mp.o.TierGrowthFactor))
+}
+
+// calcBudget computes how many segments a given tier "should" hold before it
+// must be merged down into the next tier.
+func (mp *tieredMergePlanner) calcBudget(tier int) int {
+	return mp.o.MaxSegmentsPerTier
+}
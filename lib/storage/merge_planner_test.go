@@ -0,0 +1,73 @@
+package storage
+
+import "testing"
+
+func newTestPartWrapperWithRows(rows uint64) *partWrapper {
+	return &partWrapper{
+		p: &part{
+			ph: partHeader{
+				RowsCount: rows,
+			},
+		},
+	}
+}
+
+func TestCapPartsByRowBudget(t *testing.T) {
+	parts := []*partWrapper{
+		newTestPartWrapperWithRows(10),
+		newTestPartWrapperWithRows(20),
+		newTestPartWrapperWithRows(30),
+		newTestPartWrapperWithRows(40),
+	}
+
+	if got := capPartsByRowBudget(parts, 0); len(got) != len(parts) {
+		t.Fatalf("capPartsByRowBudget() with maxOutputRows=0 must return all parts unbounded; got %d parts", len(got))
+	}
+
+	got := capPartsByRowBudget(parts, 35)
+	if len(got) != 2 {
+		t.Fatalf("capPartsByRowBudget() must stop once adding another part would exceed the budget; got %d parts, want 2", len(got))
+	}
+	var rowsSum uint64
+	for _, pw := range got {
+		rowsSum += pw.p.ph.RowsCount
+	}
+	if rowsSum > 35 {
+		t.Fatalf("capPartsByRowBudget() returned parts summing to %d rows, which exceeds the budget of 35", rowsSum)
+	}
+
+	if got := capPartsByRowBudget(parts[:1], 5); got != nil {
+		t.Fatalf("capPartsByRowBudget() must return nil when fewer than 2 parts fit the budget; got %d parts", len(got))
+	}
+}
+
+func TestTieredMergePlannerPlanRespectsMaxOutputRows(t *testing.T) {
+	mp := newTieredMergePlanner(MergePlannerOptions{
+		FloorSegmentSize:     1,
+		MaxSegmentsPerTier:   2,
+		TierGrowthFactor:     4,
+		SegmentsPerMergeTask: 4,
+	})
+
+	smallParts := []*partWrapper{
+		newTestPartWrapperWithRows(100),
+		newTestPartWrapperWithRows(100),
+		newTestPartWrapperWithRows(100),
+		newTestPartWrapperWithRows(100),
+	}
+
+	tasks := mp.Plan(smallParts, nil, false, 250)
+	if len(tasks) != 1 {
+		t.Fatalf("Plan() with a 250-row budget must still return a task merging the smallest parts that fit; got %d tasks", len(tasks))
+	}
+	var rowsSum uint64
+	for _, pw := range tasks[0].Parts {
+		rowsSum += pw.p.ph.RowsCount
+	}
+	if rowsSum > 250 {
+		t.Fatalf("Plan() returned a task summing to %d rows, which exceeds maxOutputRows=250", rowsSum)
+	}
+	if len(tasks[0].Parts) != 2 {
+		t.Fatalf("Plan() must cap the task to the parts that fit the budget; got %d parts, want 2", len(tasks[0].Parts))
+	}
+}
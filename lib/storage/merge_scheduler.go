@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// SchedulerMergeTask describes a single merge to run on behalf of a partition.
+//
+// SchedulerMergeTask is distinct from the planner's MergeTask: the planner
+// decides *what* to merge inside one partition, while the scheduler decides
+// *when* across all partitions in the process.
+type SchedulerMergeTask struct {
+	// Run performs the merge. It is invoked on a scheduler worker goroutine.
+	Run func()
+
+	// Tier is small or big; it determines which concurrency budget applies.
+	Tier MergeTier
+
+	// IsAssist marks a synchronous, high-priority submission made directly
+	// from the ingestion path (see partition.addRowsPart) to provide
+	// backpressure once maxSmallPartsPerPartition is exceeded.
+	IsAssist bool
+
+	// SmallPartsBacklog is the number of small parts in the submitting
+	// partition relative to maxSmallPartsPerPartition, used to prioritize
+	// partitions under the most backpressure.
+	SmallPartsBacklog float64
+
+	// OutputSizeEstimate estimates the number of rows the merge will
+	// produce, used to cap concurrent big merges by available disk space.
+	OutputSizeEstimate uint64
+
+	// BigPartsPath is the submitting partition's bigPartsPath, used to look
+	// up free disk space for big-tier tasks. Unused for small-tier tasks.
+	BigPartsPath string
+
+	done chan struct{}
+}
+
+// priority returns a score where higher runs sooner.
+func (t *SchedulerMergeTask) priority() float64 {
+	p := t.SmallPartsBacklog
+	if t.IsAssist {
+		// Assisted merges block an ingestion goroutine, so they must never
+		// starve behind best-effort background merges.
+		p += 1000
+	}
+	return p
+}
+
+// MergeScheduler is a process-wide scheduler for merge tasks submitted by
+// every partition, so N partitions don't each spawn their own independent
+// pool of mergers competing blindly for CPU and disk (see startMergeWorkers
+// in partition.go, which only starts one merge-driving goroutine per tier).
+//
+// Concurrent small merges are capped by GOMAXPROCS. Concurrent big merges
+// are capped by available disk space on the target volume divided by the
+// projected output size of in-flight merges.
+type MergeScheduler struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	queue taskHeap
+
+	smallInFlight int
+	bigInFlight   int
+
+	// bigInFlightRows is the summed OutputSizeEstimate of every big merge
+	// currently running, so hasBudgetLocked can tell whether an incoming
+	// big merge's projected output still fits in free disk space alongside them.
+	bigInFlightRows uint64
+
+	maxSmall int
+
+	stopped bool
+}
+
+var (
+	globalMergeScheduler     *MergeScheduler
+	globalMergeSchedulerOnce sync.Once
+)
+
+// GetMergeScheduler returns the process-wide MergeScheduler, creating it on
+// first use.
+func GetMergeScheduler() *MergeScheduler {
+	globalMergeSchedulerOnce.Do(func() {
+		globalMergeScheduler = newMergeScheduler()
+	})
+	return globalMergeScheduler
+}
+
+func newMergeScheduler() *MergeScheduler {
+	maxSmall := runtime.GOMAXPROCS(-1)
+	if maxSmall < 1 {
+		maxSmall = 1
+	}
+	s := &MergeScheduler{
+		maxSmall: maxSmall,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	for i := 0; i < maxSmall; i++ {
+		go s.workerLoop()
+	}
+	return s
+}
+
+// Submit schedules task according to priority and blocks the caller until it
+// has run. Callers with task.IsAssist set are ingestion goroutines blocked
+// on backpressure, so they jump ahead of best-effort background merges;
+// every submission is otherwise handled the same way.
+func (s *MergeScheduler) Submit(task *SchedulerMergeTask) {
+	task.done = make(chan struct{})
+
+	s.mu.Lock()
+	heap.Push(&s.queue, task)
+	s.mu.Unlock()
+	s.cond.Signal()
+
+	<-task.done
+}
+
+func (s *MergeScheduler) workerLoop() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.stopped {
+			s.cond.Wait()
+		}
+		if s.stopped {
+			s.mu.Unlock()
+			return
+		}
+
+		task := s.popRunnableLocked()
+		if task == nil {
+			// Nothing currently fits the available budget; wait for a slot
+			// to free up or a new, possibly smaller, task to arrive.
+			s.cond.Wait()
+			s.mu.Unlock()
+			continue
+		}
+
+		if task.Tier == MergeTierBig {
+			s.bigInFlight++
+			s.bigInFlightRows += task.OutputSizeEstimate
+		} else {
+			s.smallInFlight++
+		}
+		s.mu.Unlock()
+
+		s.runTask(task)
+
+		s.mu.Lock()
+		if task.Tier == MergeTierBig {
+			s.bigInFlight--
+			s.bigInFlightRows -= task.OutputSizeEstimate
+		} else {
+			s.smallInFlight--
+		}
+		s.mu.Unlock()
+		s.cond.Broadcast()
+	}
+}
+
+// popRunnableLocked pops and returns the highest-priority task whose tier
+// still has budget, or nil if none currently fit. Must be called with s.mu held.
+func (s *MergeScheduler) popRunnableLocked() *SchedulerMergeTask {
+	var deferred []*SchedulerMergeTask
+	var result *SchedulerMergeTask
+	for len(s.queue) > 0 {
+		t := heap.Pop(&s.queue).(*SchedulerMergeTask)
+		if s.hasBudgetLocked(t) {
+			result = t
+			break
+		}
+		deferred = append(deferred, t)
+	}
+	for _, t := range deferred {
+		heap.Push(&s.queue, t)
+	}
+	return result
+}
+
+func (s *MergeScheduler) hasBudgetLocked(t *SchedulerMergeTask) bool {
+	if t.Tier == MergeTierBig {
+		return s.bigMergeFitsLocked(t)
+	}
+	return s.smallInFlight < s.maxSmall
+}
+
+// bigMergeFitsLocked reports whether t's projected output, together with the
+// output of every already in-flight big merge, still fits within the free
+// disk space available on t.BigPartsPath.
+func (s *MergeScheduler) bigMergeFitsLocked(t *SchedulerMergeTask) bool {
+	if s.bigInFlight == 0 {
+		// Always let at least one big merge proceed so progress is never
+		// blocked entirely, even if disk space is tight enough that a
+		// second concurrent merge wouldn't fit.
+		return true
+	}
+	if t.BigPartsPath == "" || t.OutputSizeEstimate == 0 {
+		return s.bigInFlight < s.maxSmall
+	}
+	freeSpace := mustGetFreeDiskSpace(t.BigPartsPath)
+	return s.bigInFlightRows+t.OutputSizeEstimate <= freeSpace
+}
+
+func (s *MergeScheduler) runTask(task *SchedulerMergeTask) {
+	defer func() {
+		if task.done != nil {
+			close(task.done)
+		}
+		if r := recover(); r != nil {
+			logger.Panicf("FATAL: panic while running scheduled merge task: %v", r)
+		}
+	}()
+	task.Run()
+}
+
+// Stop shuts down the scheduler's worker goroutines. It is used only in
+// tests; production processes keep the scheduler alive for the process lifetime.
+func (s *MergeScheduler) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// taskHeap is a max-heap of *SchedulerMergeTask ordered by priority().
+type taskHeap []*SchedulerMergeTask
+
+func (h taskHeap) Len() int            { return len(h) }
+func (h taskHeap) Less(i, j int) bool  { return h[i].priority() > h[j].priority() }
+func (h taskHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *taskHeap) Push(x interface{}) { *h = append(*h, x.(*SchedulerMergeTask)) }
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
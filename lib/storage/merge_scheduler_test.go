@@ -0,0 +1,24 @@
+package storage
+
+import "testing"
+
+func TestMergeSchedulerBigMergeFitsLocked(t *testing.T) {
+	dir := t.TempDir()
+	s := &MergeScheduler{maxSmall: 4}
+
+	hugeTask := &SchedulerMergeTask{BigPartsPath: dir, OutputSizeEstimate: 1 << 62}
+	if !s.bigMergeFitsLocked(hugeTask) {
+		t.Fatalf("bigMergeFitsLocked() must let the first big merge through regardless of its size estimate")
+	}
+
+	s.bigInFlight = 1
+	s.bigInFlightRows = 1
+	if s.bigMergeFitsLocked(hugeTask) {
+		t.Fatalf("bigMergeFitsLocked() must reject a merge whose estimate, added to in-flight rows, exceeds free disk space")
+	}
+
+	smallTask := &SchedulerMergeTask{BigPartsPath: dir, OutputSizeEstimate: 1}
+	if !s.bigMergeFitsLocked(smallTask) {
+		t.Fatalf("bigMergeFitsLocked() must accept a merge that comfortably fits the available free disk space")
+	}
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// txnLogMagic identifies a well-formed record header in a mergeTxnLog file.
+const txnLogMagic = 0x54584e31 // "TXN1"
+
+// txnLogHeaderSize is the size, in bytes, of a record header: magic (4) +
+// body length (4) + body CRC32 (4).
+const txnLogHeaderSize = 4 + 4 + 4
+
+// mergeTxnLog coalesces every merge and retention-drop transaction for one
+// partition into a single append-only file, instead of one file per
+// transaction under <ptPath>/txn. This avoids a separate file
+// create/rename/fsync/remove cycle per transaction once concurrent merges
+// (see partition.submitMergeAsync) can be in flight at the same time.
+//
+// Each record is self-describing (magic + length + CRC32), mirroring how
+// wal.go frames rawRow records, so runTransactionLog can recover by
+// scanning the file forward and stop cleanly at a torn tail left by a crash
+// mid-append.
+type mergeTxnLog struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openMergeTxnLog(path string) (*mergeTxnLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open transaction log %q: %s", path, err)
+	}
+	return &mergeTxnLog{
+		path: path,
+		f:    f,
+	}, nil
+}
+
+// Append durably appends body - a transaction in the same
+// "rmPath\n...\nsrcPath -> dstPath\n" format runTransaction has always used
+// - to the log before it is applied, so a crash between the two can still
+// be recovered by runTransactionLog.
+func (l *mergeTxnLog) Append(body []byte) error {
+	hdr := make([]byte, txnLogHeaderSize)
+	binary.BigEndian.PutUint32(hdr[0:4], txnLogMagic)
+	binary.BigEndian.PutUint32(hdr[4:8], uint32(len(body)))
+	binary.BigEndian.PutUint32(hdr[8:12], crc32.ChecksumIEEE(body))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.f.Write(hdr); err != nil {
+		return fmt.Errorf("cannot append transaction header to %q: %s", l.path, err)
+	}
+	if _, err := l.f.Write(body); err != nil {
+		return fmt.Errorf("cannot append transaction body to %q: %s", l.path, err)
+	}
+	return l.f.Sync()
+}
+
+// Reset truncates the log to empty. It is called once at partition open
+// time, right after every record in it has been replayed by
+// runTransactionLog, and again every time commitRootSnapshot persists a root
+// manifest, since the manifest then already reflects every transaction
+// applied up to that point and none of them need replaying again.
+func (l *mergeTxnLog) Reset() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.f.Truncate(0); err != nil {
+		return fmt.Errorf("cannot truncate transaction log %q: %s", l.path, err)
+	}
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot rewind transaction log %q: %s", l.path, err)
+	}
+	return nil
+}
+
+func (l *mergeTxnLog) MustClose() {
+	if err := l.f.Close(); err != nil {
+		logger.Panicf("FATAL: cannot close transaction log %q: %s", l.path, err)
+	}
+}
+
+// runTransactionLog replays every well-formed record at path in order,
+// applying each one via applyTxnBody. A torn tail left by a crash
+// mid-append (an incomplete header, or a body shorter than its declared
+// length, or a CRC mismatch) is tolerated and stops the scan, the same way
+// replayWAL tolerates a torn WAL tail.
+func runTransactionLog(txnLock *sync.RWMutex, pathPrefix1, pathPrefix2, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot read transaction log %q: %s", path, err)
+	}
+
+	for len(data) > 0 {
+		if len(data) < txnLogHeaderSize {
+			break
+		}
+		magic := binary.BigEndian.Uint32(data[0:4])
+		size := binary.BigEndian.Uint32(data[4:8])
+		crc := binary.BigEndian.Uint32(data[8:12])
+		if magic != txnLogMagic || uint64(len(data)) < uint64(txnLogHeaderSize)+uint64(size) {
+			break
+		}
+		body := data[txnLogHeaderSize : uint64(txnLogHeaderSize)+uint64(size)]
+		if crc32.ChecksumIEEE(body) != crc {
+			break
+		}
+
+		if err := applyTxnBody(txnLock, pathPrefix1, pathPrefix2, body); err != nil {
+			return fmt.Errorf("cannot apply transaction from log %q: %s", path, err)
+		}
+
+		data = data[uint64(txnLogHeaderSize)+uint64(size):]
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// ObjectStoreClient is the minimal surface object-store-backed PartStorage
+// needs from a concrete S3/GCS/Azure SDK client, so lib/storage doesn't
+// depend directly on any of them. An app wires up a concrete implementation
+// (e.g. backed by the AWS SDK's S3 client) at startup.
+type ObjectStoreClient interface {
+	// PutObject uploads the contents of r under key, using multipart upload
+	// semantics for large objects.
+	PutObject(key string, r io.Reader) error
+
+	// GetObject returns a reader for the object at key.
+	GetObject(key string) (io.ReadCloser, error)
+
+	// DeleteObject removes the object at key. Missing keys aren't an error.
+	DeleteObject(key string) error
+
+	// ListKeys returns every key under prefix.
+	ListKeys(prefix string) ([]string, error)
+}
+
+// objectPartStorage is a PartStorage backed by an object store, with a local
+// on-disk page cache for blocks read during search.
+//
+// Part directories are mapped to a "<prefix>/<partName>/" key prefix; each
+// file within the part becomes one object under that prefix.
+type objectPartStorage struct {
+	client    ObjectStoreClient
+	keyPrefix string
+
+	cacheDir string
+
+	cacheMu sync.Mutex
+}
+
+// NewObjectPartStorage returns a PartStorage that stores big parts in an
+// object store reachable via client, using keyPrefix as the root for all
+// part keys and cacheDir as a local page cache for reads.
+func NewObjectPartStorage(client ObjectStoreClient, keyPrefix, cacheDir string) PartStorage {
+	return &objectPartStorage{
+		client:    client,
+		keyPrefix: keyPrefix,
+		cacheDir:  cacheDir,
+	}
+}
+
+func (s *objectPartStorage) key(partPath, relPath string) string {
+	return filepath.Clean(s.keyPrefix+"/"+filepath.Base(partPath)) + "/" + relPath
+}
+
+func (s *objectPartStorage) cachePath(partPath, relPath string) string {
+	return filepath.Clean(s.cacheDir+"/"+filepath.Base(partPath)) + "/" + relPath
+}
+
+func (s *objectPartStorage) Open(partPath, relPath string) (io.ReadCloser, error) {
+	cp := s.cachePath(partPath, relPath)
+	if f, err := os.Open(cp); err == nil {
+		return f, nil
+	}
+
+	r, err := s.client.GetObject(s.key(partPath, relPath))
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch %q from object store: %s", s.key(partPath, relPath), err)
+	}
+	defer r.Close()
+
+	if err := fs.MkdirAllIfNotExist(filepath.Dir(cp)); err != nil {
+		return nil, fmt.Errorf("cannot create page cache directory for %q: %s", cp, err)
+	}
+	f, err := os.Create(cp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create page cache file %q: %s", cp, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot populate page cache file %q: %s", cp, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot rewind page cache file %q: %s", cp, err)
+	}
+	return f, nil
+}
+
+// Create returns a writer that buffers to a local temp file and uploads it
+// to the object store as a multipart PutObject on Close, so merge output
+// streams to the remote backend without holding the whole part in memory.
+func (s *objectPartStorage) Create(partPath, relPath string) (io.WriteCloser, error) {
+	tmpPath := s.cachePath(partPath, relPath) + ".upload"
+	if err := fs.MkdirAllIfNotExist(filepath.Dir(tmpPath)); err != nil {
+		return nil, fmt.Errorf("cannot create upload staging directory for %q: %s", tmpPath, err)
+	}
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create upload staging file %q: %s", tmpPath, err)
+	}
+	return &objectUploadWriter{
+		s:        s,
+		f:        f,
+		tmpPath:  tmpPath,
+		key:      s.key(partPath, relPath),
+		partPath: partPath,
+		relPath:  relPath,
+	}, nil
+}
+
+type objectUploadWriter struct {
+	s        *objectPartStorage
+	f        *os.File
+	tmpPath  string
+	key      string
+	partPath string
+	relPath  string
+}
+
+func (w *objectUploadWriter) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+func (w *objectUploadWriter) Close() error {
+	if err := w.f.Sync(); err != nil {
+		w.f.Close()
+		return fmt.Errorf("cannot fsync upload staging file %q: %s", w.tmpPath, err)
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		w.f.Close()
+		return fmt.Errorf("cannot rewind upload staging file %q: %s", w.tmpPath, err)
+	}
+	err := w.s.client.PutObject(w.key, w.f)
+	w.f.Close()
+	if err != nil {
+		return fmt.Errorf("cannot upload %q to object store: %s", w.key, err)
+	}
+
+	// Seed the local page cache with the just-written file, since it's
+	// almost always about to be read back (e.g. for verification or search).
+	cp := w.s.cachePath(w.partPath, w.relPath)
+	if err := fs.MkdirAllIfNotExist(filepath.Dir(cp)); err != nil {
+		logger.Errorf("cannot create page cache directory for %q: %s", cp, err)
+		return nil
+	}
+	if err := os.Rename(w.tmpPath, cp); err != nil {
+		logger.Errorf("cannot seed page cache at %q: %s", cp, err)
+	}
+	return nil
+}
+
+func (s *objectPartStorage) Remove(partPath string) error {
+	prefix := s.key(partPath, "")
+	keys, err := s.client.ListKeys(prefix)
+	if err != nil {
+		return fmt.Errorf("cannot list keys under %q: %s", prefix, err)
+	}
+	for _, k := range keys {
+		if err := s.client.DeleteObject(k); err != nil {
+			return fmt.Errorf("cannot delete %q: %s", k, err)
+		}
+	}
+	_ = os.RemoveAll(s.cachePath(partPath, ""))
+	return nil
+}
+
+// Sync is a no-op for object stores: PutObject is already durable once it
+// returns successfully.
+func (s *objectPartStorage) Sync(partPath string) {}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+// PartStorage abstracts a destination that big part directories can be
+// mirrored to in addition to the local bigPartsPath, for off-site durability
+// against an object store (S3/GCS/Azure), following a keepstore-style volume
+// abstraction.
+//
+// Implementations operate on whole part directories identified by their
+// path (local) or key prefix (remote); individual files within a part are
+// addressed relative to that root.
+//
+// This only backs the write-side mirror in mergeParts/dropExpiredParts
+// (upload on create via Create, remove on supersede/drop via Remove); Open
+// exists for a future read path that serves big parts straight from the
+// remote mirror instead of bigPartsPath, which this backend doesn't
+// implement yet.
+type PartStorage interface {
+	// Open returns a reader for the file at the given path relative to a part's root.
+	Open(partPath, relPath string) (io.ReadCloser, error)
+
+	// Create returns a writer for the file at the given path relative to a
+	// part's root, creating parent directories/prefixes as needed.
+	Create(partPath, relPath string) (io.WriteCloser, error)
+
+	// Remove deletes a whole part directory/prefix.
+	Remove(partPath string) error
+
+	// Sync flushes any buffered metadata for partPath to durable storage.
+	Sync(partPath string)
+}
+
+// localPartStorage is the default PartStorage backed by the local filesystem,
+// preserving the historical behavior of bigPartsPath.
+type localPartStorage struct{}
+
+func (localPartStorage) Open(partPath, relPath string) (io.ReadCloser, error) {
+	return os.Open(partPath + "/" + relPath)
+}
+
+func (localPartStorage) Create(partPath, relPath string) (io.WriteCloser, error) {
+	if err := fs.MkdirAllIfNotExist(partPath); err != nil {
+		return nil, fmt.Errorf("cannot create directory %q: %s", partPath, err)
+	}
+	return os.Create(partPath + "/" + relPath)
+}
+
+func (localPartStorage) Remove(partPath string) error {
+	return os.RemoveAll(partPath)
+}
+
+func (localPartStorage) Sync(partPath string) {
+	fs.SyncPath(partPath)
+}
+
+// defaultBigPartStorage is used by partitions that don't opt into a remote backend.
+var defaultBigPartStorage PartStorage = localPartStorage{}
+
+// copyPartToStorage uploads every file under the local directory localPartPath
+// to dst, addressed by remotePartPath's base name rather than localPartPath's,
+// so it can be called against a part still sitting under tmp/ (before the
+// merge transaction has renamed it to its final name) while still landing on
+// dst under the name callers will look it up by. It's used to promote a part
+// that was merged locally (blockStreamWriter only knows how to write to the
+// local filesystem) onto a remote PartStorage once the merge completes.
+func copyPartToStorage(localPartPath, remotePartPath string, dst PartStorage) error {
+	entries, err := os.ReadDir(localPartPath)
+	if err != nil {
+		return fmt.Errorf("cannot list part directory %q: %s", localPartPath, err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := copyPartFile(localPartPath, remotePartPath, e.Name(), dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyPartFile(localPartPath, remotePartPath, relPath string, dst PartStorage) error {
+	src, err := os.Open(localPartPath + "/" + relPath)
+	if err != nil {
+		return fmt.Errorf("cannot open %q/%q: %s", localPartPath, relPath, err)
+	}
+	defer src.Close()
+
+	w, err := dst.Create(remotePartPath, relPath)
+	if err != nil {
+		return fmt.Errorf("cannot create %q/%q on remote storage: %s", remotePartPath, relPath, err)
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		w.Close()
+		return fmt.Errorf("cannot upload %q/%q: %s", localPartPath, relPath, err)
+	}
+	return w.Close()
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+// memPartStorage is a trivial in-memory PartStorage used to assert what
+// copyPartToStorage actually writes, without touching a real remote backend.
+type memPartStorage struct {
+	files map[string][]byte
+}
+
+func newMemPartStorage() *memPartStorage {
+	return &memPartStorage{files: make(map[string][]byte)}
+}
+
+func (m *memPartStorage) Open(partPath, relPath string) (io.ReadCloser, error) {
+	data, ok := m.files[partPath+"/"+relPath]
+	if !ok {
+		return nil, fmt.Errorf("not found: %s/%s", partPath, relPath)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+type memWriteCloser struct {
+	m   *memPartStorage
+	key string
+	buf bytes.Buffer
+}
+
+func (w *memWriteCloser) Write(p []byte) (int, error) { return w.buf.Write(p) }
+func (w *memWriteCloser) Close() error {
+	w.m.files[w.key] = w.buf.Bytes()
+	return nil
+}
+
+func (m *memPartStorage) Create(partPath, relPath string) (io.WriteCloser, error) {
+	return &memWriteCloser{m: m, key: partPath + "/" + relPath}, nil
+}
+
+func (m *memPartStorage) Remove(partPath string) error { return nil }
+func (m *memPartStorage) Sync(partPath string)         {}
+
+func TestCopyPartToStorageUsesRemotePartPathName(t *testing.T) {
+	localDir := t.TempDir()
+	if err := os.WriteFile(localDir+"/data.bin", []byte("hello"), 0644); err != nil {
+		t.Fatalf("cannot write local test file: %s", err)
+	}
+
+	dst := newMemPartStorage()
+	remotePartPath := "/bigparts/0000000000ABCDEF"
+	if err := copyPartToStorage(localDir, remotePartPath, dst); err != nil {
+		t.Fatalf("copyPartToStorage() failed: %s", err)
+	}
+
+	data, ok := dst.files[remotePartPath+"/data.bin"]
+	if !ok {
+		t.Fatalf("copyPartToStorage() did not write under remotePartPath %q; got keys %v", remotePartPath, dst.files)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("unexpected uploaded contents: got %q, want %q", data, "hello")
+	}
+	if _, ok := dst.files[localDir+"/data.bin"]; ok {
+		t.Fatalf("copyPartToStorage() must not key uploads by the local tmp path %q", localDir)
+	}
+}
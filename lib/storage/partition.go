@@ -92,6 +92,22 @@ type partition struct {
 	smallPartsPath string
 	bigPartsPath   string
 
+	// opts controls partition bucketing and the precision of incoming timestamps.
+	opts StorageOptions
+
+	// bigPartStorage is a remote mirror that big parts are additionally
+	// uploaded to once written locally, kept in sync as parts are
+	// superseded by later merges or dropped by retention (see mergeParts
+	// and dropExpiredParts). It defaults to the local filesystem, in which
+	// case it's the same directory as bigPartsPath and these copies are a
+	// no-op.
+	//
+	// Reads and snapshots still always go through bigPartsPath: plumbing
+	// them through PartStorage too would need the block-reading layer
+	// (blockStreamReader et al.) to read through it as well, which this
+	// backend doesn't do yet.
+	bigPartStorage PartStorage
+
 	// The callack that returns deleted metric ids which must be skipped during merge.
 	getDeletedMetricIDs func() map[uint64]struct{}
 
@@ -122,8 +138,34 @@ type partition struct {
 	// rawRowsLastFlushTime is the last time rawRows are flushed.
 	rawRowsLastFlushTime time.Time
 
+	// rawRowsSeal is non-nil when part of the WAL segment backing the
+	// current contents of rawRows has already been rotated out by a prior
+	// AddRows call (see sealWALLocked) but rawRows itself hasn't been
+	// durably converted into a part yet. Whichever call next flushes
+	// rawRows (fully or partially, from the front) must carry this seal
+	// along on top of any seal of its own, and is then responsible for
+	// clearing or replacing this field. Protected by rawRowsLock.
+	rawRowsSeal *walSeal
+
 	mergeIdx uint64
 
+	// wal is the write-ahead log for rawRows, so they survive a crash before
+	// being converted into an on-disk part. It is nil when DisableWAL is set.
+	wal *wal
+
+	// txnLog coalesces every merge/drop transaction for pt into a single
+	// append-only log instead of one file per transaction under /txn, so
+	// the concurrent merges submitMergeAsync dispatches don't each pay for
+	// a separate file create/rename/fsync/remove cycle. See merge_txn_log.go.
+	txnLog *mergeTxnLog
+
+	// planner decides which parts are merged together. See MergePlanner.
+	planner MergePlanner
+
+	// epochState tracks the monotonically increasing epoch and the root
+	// manifest snapshots pinned by in-flight Snapshot() callers.
+	epochState
+
 	snapshotLock sync.RWMutex
 
 	stopCh chan struct{}
@@ -132,6 +174,12 @@ type partition struct {
 	bigPartsMergerWG       sync.WaitGroup
 	rawRowsFlusherWG       sync.WaitGroup
 	inmemoryPartsFlusherWG sync.WaitGroup
+	retentionWorkerWG      sync.WaitGroup
+
+	// mergeWorkersWG tracks the goroutines submitMergeAsync spawns so
+	// MustClose can wait for them to finish (and release isInMerge on their
+	// parts) before flushing remaining inmemory parts.
+	mergeWorkersWG sync.WaitGroup
 
 	activeBigMerges   uint64
 	activeSmallMerges uint64
@@ -143,6 +191,9 @@ type partition struct {
 	smallRowsDeleted  uint64
 
 	smallAssistedMerges uint64
+
+	rowsDroppedByRetention  uint64
+	partsDroppedByRetention uint64
 }
 
 // partWrapper is a wrapper for the part.
@@ -153,11 +204,23 @@ type partWrapper struct {
 	// non-nil if the part is inmemoryPart.
 	mp *inmemoryPart
 
+	// non-empty if mp's rows are still only durably protected by one or more
+	// sealed WAL segments rather than an on-disk part. There can be more
+	// than one: a part built from pt.rawRows can straddle two rotations if
+	// the tail left behind by one AddRows call is still unflushed when the
+	// next rotation happens (see AddRows and walSeal). Released once this
+	// part (or whatever it gets merged into) is durably written to disk.
+	walSeals []*walSeal
+
 	// The number of references to the part.
 	refCount uint64
 
 	// Whether the part is in merge now.
 	isInMerge bool
+
+	// Whether the part has been dropped by the retention policy. It is kept
+	// around only so outstanding decRef holders can finalize it as usual.
+	isDropped bool
 }
 
 func (pw *partWrapper) incRef() {
@@ -182,9 +245,20 @@ func (pw *partWrapper) decRef() {
 }
 
 // createPartition creates new partition for the given timestamp and the given paths
-// to small and big partitions.
+// to small and big partitions, using the legacy calendar-month bucketing and
+// millisecond timestamps. It exists so callers predating StorageOptions keep
+// compiling; new callers that need configurable bucketing or precision should
+// use createPartitionWithOptions instead.
 func createPartition(timestamp int64, smallPartitionsPath, bigPartitionsPath string, getDeletedMetricIDs func() map[uint64]struct{}) (*partition, error) {
-	name := timestampToPartitionName(timestamp)
+	return createPartitionWithOptions(timestamp, smallPartitionsPath, bigPartitionsPath, getDeletedMetricIDs, DefaultStorageOptions)
+}
+
+// createPartitionWithOptions creates new partition for the given timestamp and the given paths
+// to small and big partitions, using opts to control partition bucketing and
+// timestamp precision.
+func createPartitionWithOptions(timestamp int64, smallPartitionsPath, bigPartitionsPath string, getDeletedMetricIDs func() map[uint64]struct{}, opts StorageOptions) (*partition, error) {
+	timestampMs := opts.toMilliseconds(timestamp)
+	name := partitionNameForTimestamp(timestampMs, opts)
 	smallPartsPath := filepath.Clean(smallPartitionsPath) + "/" + name
 	bigPartsPath := filepath.Clean(bigPartitionsPath) + "/" + name
 	logger.Infof("creating a partition %q with smallPartsPath=%q, bigPartsPath=%q", name, smallPartsPath, bigPartsPath)
@@ -195,12 +269,26 @@ func createPartition(timestamp int64, smallPartitionsPath, bigPartitionsPath str
 	if err := createPartitionDirs(bigPartsPath); err != nil {
 		return nil, fmt.Errorf("cannot create directories for big parts %q: %s", bigPartsPath, err)
 	}
+	if err := writePartitionOptions(smallPartsPath, opts); err != nil {
+		return nil, fmt.Errorf("cannot persist partition options for %q: %s", smallPartsPath, err)
+	}
 
-	pt := newPartition(name, smallPartsPath, bigPartsPath, getDeletedMetricIDs)
-	pt.tr.fromPartitionTimestamp(timestamp)
+	pt := newPartition(name, smallPartsPath, bigPartsPath, getDeletedMetricIDs, opts)
+	tr, err := timeRangeForPartitionName(name, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot derive time range for partition %q: %s", name, err)
+	}
+	pt.tr = tr
+	if err := pt.openWAL(); err != nil {
+		return nil, fmt.Errorf("cannot open WAL for partition %q: %s", name, err)
+	}
+	if err := pt.openTxnLog(); err != nil {
+		return nil, fmt.Errorf("cannot open transaction log for partition %q: %s", name, err)
+	}
 	pt.startMergeWorkers()
 	pt.startRawRowsFlusher()
 	pt.startInmemoryPartsFlusher()
+	pt.startRetentionWorker()
 
 	logger.Infof("partition %q has been created", name)
 
@@ -223,8 +311,22 @@ func (pt *partition) Drop() {
 	logger.Infof("partition %q has been dropped", pt.name)
 }
 
-// openPartition opens the existing partition from the given paths.
+// openPartition opens the existing partition from the given paths, assuming
+// it was created with DefaultStorageOptions. It exists so callers predating
+// StorageOptions keep compiling; new callers that need to open a partition
+// created with non-default bucketing or precision should use
+// openPartitionWithOptions instead.
 func openPartition(smallPartsPath, bigPartsPath string, getDeletedMetricIDs func() map[uint64]struct{}) (*partition, error) {
+	return openPartitionWithOptions(smallPartsPath, bigPartsPath, getDeletedMetricIDs, DefaultStorageOptions)
+}
+
+// openPartitionWithOptions opens the existing partition from the given paths, using
+// opts to interpret its bucket boundaries.
+//
+// opts must match the StorageOptions the partition was created with;
+// openPartitionWithOptions refuses to open it otherwise rather than silently
+// misinterpreting timestamps.
+func openPartitionWithOptions(smallPartsPath, bigPartsPath string, getDeletedMetricIDs func() map[uint64]struct{}, opts StorageOptions) (*partition, error) {
 	smallPartsPath = filepath.Clean(smallPartsPath)
 	bigPartsPath = filepath.Clean(bigPartsPath)
 
@@ -238,6 +340,10 @@ func openPartition(smallPartsPath, bigPartsPath string, getDeletedMetricIDs func
 		return nil, fmt.Errorf("patititon name in bigPartsPath %q doesn't match smallPartsPath %q; want %q", bigPartsPath, smallPartsPath, name)
 	}
 
+	if err := verifyPartitionOptions(smallPartsPath, opts); err != nil {
+		return nil, err
+	}
+
 	smallParts, err := openParts(smallPartsPath, bigPartsPath, smallPartsPath)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open small parts from %q: %s", smallPartsPath, err)
@@ -248,34 +354,178 @@ func openPartition(smallPartsPath, bigPartsPath string, getDeletedMetricIDs func
 		return nil, fmt.Errorf("cannot open big parts from %q: %s", bigPartsPath, err)
 	}
 
-	pt := newPartition(name, smallPartsPath, bigPartsPath, getDeletedMetricIDs)
+	pt := newPartition(name, smallPartsPath, bigPartsPath, getDeletedMetricIDs, opts)
 	pt.smallParts = smallParts
 	pt.bigParts = bigParts
-	if err := pt.tr.fromPartitionName(name); err != nil {
+	tr, err := timeRangeForPartitionName(name, opts)
+	if err != nil {
 		return nil, fmt.Errorf("cannot obtain partition time range from smallPartsPath %q: %s", smallPartsPath, err)
 	}
+	pt.tr = tr
+	if snap, err := readRootSnapshot(smallPartsPath); err != nil {
+		return nil, fmt.Errorf("cannot read root manifest from %q: %s", smallPartsPath, err)
+	} else if snap != nil {
+		pt.epoch = snap.Epoch
+		pt.liveSnapshots[snap.Epoch] = snap
+	}
+	if err := pt.openWAL(); err != nil {
+		return nil, fmt.Errorf("cannot open WAL for partition %q: %s", name, err)
+	}
+	rows, staleSegments, err := pt.replayWALSegments()
+	if err != nil {
+		return nil, fmt.Errorf("cannot replay WAL for partition %q: %s", name, err)
+	}
+	if len(rows) == 0 {
+		// Nothing to recover, so any stale segments left over from a crash
+		// between a rotation and the part it was waiting on are empty or
+		// torn-tail-only and safe to discard outright.
+		for _, path := range staleSegments {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				logger.Errorf("cannot remove stale WAL segment %q: %s", path, err)
+			}
+		}
+	} else {
+		logger.Infof("replaying %d rows from WAL for partition %q", len(rows), name)
+		var seals []*walSeal
+		if pt.wal != nil {
+			activePath, err := pt.wal.Rotate()
+			if err != nil {
+				return nil, fmt.Errorf("cannot rotate WAL for partition %q after replay: %s", name, err)
+			}
+			// Keep the replayed rows protected by their sealed segments
+			// until addRowsPart's part actually lands on disk, instead of
+			// discarding the WAL's only copy of them immediately.
+			seals = []*walSeal{{paths: append(staleSegments, activePath), refCount: 1}}
+		}
+		pt.addRowsPart(rows, seals)
+	}
+
+	// Replay any merge/drop transactions left over from before a crash, then
+	// start the log fresh: every record replayed here has, by construction,
+	// already been durably applied by the time this returns.
+	if err := runTransactionLog(&pt.snapshotLock, smallPartsPath, bigPartsPath, pt.txnLogPath()); err != nil {
+		return nil, fmt.Errorf("cannot replay transaction log for partition %q: %s", name, err)
+	}
+	if err := pt.openTxnLog(); err != nil {
+		return nil, fmt.Errorf("cannot open transaction log for partition %q: %s", name, err)
+	}
+	if err := pt.txnLog.Reset(); err != nil {
+		return nil, fmt.Errorf("cannot reset transaction log for partition %q: %s", name, err)
+	}
+
 	pt.startMergeWorkers()
 	pt.startRawRowsFlusher()
 	pt.startInmemoryPartsFlusher()
+	pt.startRetentionWorker()
 
 	return pt, nil
 }
 
-func newPartition(name, smallPartsPath, bigPartsPath string, getDeletedMetricIDs func() map[uint64]struct{}) *partition {
+// walPath returns the path to the WAL directory file for pt.
+func (pt *partition) walPath() string {
+	return filepath.Clean(pt.smallPartsPath) + "/wal/wal.bin"
+}
+
+// replayWALSegments replays the active WAL segment plus any sealed segments
+// left behind by a crash between a rotation and the on-disk part it was
+// waiting on (see wal.Rotate), returning their rows with the sealed segments
+// first, in lexical order of their paths, and the active segment last,
+// together with the stale segment paths found.
+func (pt *partition) replayWALSegments() ([]rawRow, []string, error) {
+	stale, err := filepath.Glob(pt.walPath() + ".*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot list sealed WAL segments for %q: %s", pt.walPath(), err)
+	}
+	sort.Strings(stale)
+
+	var rows []rawRow
+	for _, path := range append(append([]string{}, stale...), pt.walPath()) {
+		rs, err := replayWAL(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot replay WAL segment %q: %s", path, err)
+		}
+		rows = append(rows, rs...)
+	}
+	return rows, stale, nil
+}
+
+// openWAL opens (or creates) the WAL for pt unless DisableWAL is set.
+func (pt *partition) openWAL() error {
+	if DisableWAL {
+		return nil
+	}
+	w, err := openWAL(pt.walPath(), defaultWALBufferedSize)
+	if err != nil {
+		return err
+	}
+	pt.wal = w
+	return nil
+}
+
+// txnLogPath returns the path to pt's coalesced merge/drop transaction log.
+func (pt *partition) txnLogPath() string {
+	return filepath.Clean(pt.smallPartsPath) + "/txn.log"
+}
+
+// openTxnLog opens (or creates) pt's coalesced transaction log.
+func (pt *partition) openTxnLog() error {
+	l, err := openMergeTxnLog(pt.txnLogPath())
+	if err != nil {
+		return err
+	}
+	pt.txnLog = l
+	return nil
+}
+
+// runMergeTxn durably appends body to pt.txnLog (if enabled) and then
+// applies it, so a merge or drop transaction survives a crash between the
+// two steps. Concurrent calls are safe as long as their underlying part
+// paths are disjoint, since planAndMark guarantees that via isInMerge.
+func (pt *partition) runMergeTxn(body []byte) error {
+	if pt.txnLog != nil {
+		if err := pt.txnLog.Append(body); err != nil {
+			return err
+		}
+	}
+	return applyTxnBody(&pt.snapshotLock, pt.smallPartsPath, pt.bigPartsPath, body)
+}
+
+func newPartition(name, smallPartsPath, bigPartsPath string, getDeletedMetricIDs func() map[uint64]struct{}, opts StorageOptions) *partition {
+	plannerOpts, err := LoadMergePlannerOptions(mergePlannerOptionsPath(smallPartsPath))
+	if err != nil {
+		logger.Panicf("FATAL: cannot load merge planner options for partition %q: %s", name, err)
+	}
+
+	bigPartStorage := opts.BigPartStorage
+	if bigPartStorage == nil {
+		bigPartStorage = defaultBigPartStorage
+	}
+
 	return &partition{
 		name:           name,
 		smallPartsPath: smallPartsPath,
 		bigPartsPath:   bigPartsPath,
 
+		opts:           opts,
+		bigPartStorage: bigPartStorage,
+
 		getDeletedMetricIDs: getDeletedMetricIDs,
 
 		rawRows: getRawRowsMaxSize().rows,
 
-		mergeIdx: uint64(time.Now().UnixNano()),
-		stopCh:   make(chan struct{}),
+		mergeIdx:   uint64(time.Now().UnixNano()),
+		planner:    NewMergePlanner(plannerOpts),
+		epochState: newEpochState(),
+		stopCh:     make(chan struct{}),
 	}
 }
 
+// mergePlannerOptionsPath returns the path to the optional JSON file with
+// MergePlannerOptions for the partition rooted at smallPartsPath.
+func mergePlannerOptionsPath(smallPartsPath string) string {
+	return filepath.Clean(smallPartsPath) + "/merge_planner.json"
+}
+
 // partitionMetrics contains essential metrics for the partition.
 type partitionMetrics struct {
 	PendingRows uint64
@@ -313,6 +563,33 @@ type partitionMetrics struct {
 	SmallPartsRefCount uint64
 
 	SmallAssistedMerges uint64
+
+	RowsDroppedByRetention  uint64
+	PartsDroppedByRetention uint64
+
+	// SmallLevelMergeStats and BigLevelMergeStats report per-level merge
+	// backlog (part count, pending bytes, estimated write amplification)
+	// from pt.planner, for planners that track levels (currently only the
+	// "leveled" planner; see leveledMergePlanner.AppendLevelMergeStats).
+	// They are nil for planners that don't.
+	SmallLevelMergeStats []LevelMergeStats
+	BigLevelMergeStats   []LevelMergeStats
+}
+
+// LevelMergeStats is a point-in-time snapshot of one merge-planner level's
+// backlog, as reported by MergePlanner implementations that track levels.
+type LevelMergeStats struct {
+	Level             int
+	PartsCount        int
+	PendingBytes      uint64
+	EstimatedWriteAmp float64
+}
+
+// levelMetricsProvider is implemented by MergePlanner backends that track
+// per-level backlog stats, so UpdateMetrics can expose them without every
+// MergePlanner needing to.
+type levelMetricsProvider interface {
+	AppendLevelMergeStats(dst []LevelMergeStats, tier MergeTier) []LevelMergeStats
 }
 
 // UpdateMetrics updates m with metrics from pt.
@@ -370,6 +647,14 @@ func (pt *partition) UpdateMetrics(m *partitionMetrics) {
 	m.SmallRowsDeleted += atomic.LoadUint64(&pt.smallRowsDeleted)
 
 	m.SmallAssistedMerges += atomic.LoadUint64(&pt.smallAssistedMerges)
+
+	m.RowsDroppedByRetention += atomic.LoadUint64(&pt.rowsDroppedByRetention)
+	m.PartsDroppedByRetention += atomic.LoadUint64(&pt.partsDroppedByRetention)
+
+	if lp, ok := pt.planner.(levelMetricsProvider); ok {
+		m.SmallLevelMergeStats = lp.AppendLevelMergeStats(m.SmallLevelMergeStats, MergeTierSmall)
+		m.BigLevelMergeStats = lp.AppendLevelMergeStats(m.BigLevelMergeStats, MergeTierBig)
+	}
 }
 
 // AddRows adds the given rows to the partition pt.
@@ -381,9 +666,11 @@ func (pt *partition) AddRows(rows []rawRow) {
 		return
 	}
 
-	// Validate all the rows.
+	// Normalize timestamps to milliseconds according to pt.opts.TimestampPrecision,
+	// then validate all the rows.
 	for i := range rows {
 		r := &rows[i]
+		r.Timestamp = pt.opts.toMilliseconds(r.Timestamp)
 		if !pt.HasTimestamp(r.Timestamp) {
 			logger.Panicf("BUG: row %+v has Timestamp outside partition %q range %+v", r, pt.smallPartsPath, &pt.tr)
 		}
@@ -392,9 +679,29 @@ func (pt *partition) AddRows(rows []rawRow) {
 		}
 	}
 
-	// Try adding rows.
+	// Append rows to the WAL and buffer them in rawRows atomically under
+	// rawRowsLock, so that sealWALLocked's rotation can never race with a
+	// concurrent AddRows call: whatever is physically in the WAL segment
+	// being sealed off is exactly (and only) what's being swapped out of
+	// pt.rawRows here, never a row some other goroutine already appended
+	// to the WAL but hasn't buffered into pt.rawRows yet.
 	var rrs []*rawRows
 	pt.rawRowsLock.Lock()
+	if pt.wal != nil {
+		if err := pt.wal.Append(rows); err != nil {
+			pt.rawRowsLock.Unlock()
+			logger.Panicf("FATAL: cannot append rows to WAL on %q: %s", pt.smallPartsPath, err)
+		}
+	}
+	// prevSeal, if any, protects whatever was already sitting in pt.rawRows
+	// before this call - rows from an earlier AddRows that rotated the WAL
+	// but left a tail in pt.rawRows still unflushed. Those rows were never
+	// re-appended to the WAL, so they're still durable only in prevSeal's
+	// segment(s), even though the rows this call just appended above land
+	// in a different (current) segment. If the capacity loop below carves
+	// out a first chunk, it's the only one that can contain any of
+	// prevSeal's rows (see the comment next to rrs[0]'s dispatch).
+	prevSeal := pt.rawRowsSeal
 	for {
 		capacity := cap(pt.rawRows) - len(pt.rawRows)
 		if capacity >= len(rows) {
@@ -412,14 +719,63 @@ func (pt *partition) AddRows(rows []rawRow) {
 		rrs = append(rrs, rr)
 		pt.rawRowsLastFlushTime = time.Now()
 	}
+	var newSeal *walSeal
+	if len(rrs) > 0 {
+		// The rotated-out segment covers every row appended by this call:
+		// both what ended up in rrs and whatever remains in pt.rawRows as
+		// the new tail (if any), so the refCount accounts for one
+		// reference per rrs chunk plus one more for that tail.
+		hasTail := len(pt.rawRows) > 0
+		refCount := int32(len(rrs))
+		if hasTail {
+			refCount++
+		}
+		newSeal = pt.sealWALLocked(refCount)
+		// The tail, if any, is now protected solely by newSeal: any rows
+		// prevSeal was protecting got folded into rrs[0] below, not into
+		// the tail.
+		if hasTail {
+			pt.rawRowsSeal = newSeal
+		} else {
+			pt.rawRowsSeal = nil
+		}
+	}
 	pt.rawRowsLock.Unlock()
 
-	for _, rr := range rrs {
-		pt.addRowsPart(rr.rows)
+	for i, rr := range rrs {
+		seals := []*walSeal{newSeal}
+		if i == 0 && prevSeal != nil {
+			// rrs[0] is built by filling pt.rawRows (which started out
+			// holding prevSeal's rows) up to capacity with this call's new
+			// rows, so it's the only chunk that can mix both generations;
+			// every later chunk is carved purely from this call's own rows.
+			seals = append(seals, prevSeal)
+		}
+		pt.addRowsPart(rr.rows, seals)
 		putRawRows(rr)
 	}
 }
 
+// sealWALLocked seals off the WAL's current segment (see wal.Rotate) and
+// wraps it in a reference-counted handle that the caller must release once
+// refCount parts made from the rows being swapped out of pt.rawRows have
+// been durably written to disk (see mergeParts). It returns nil if the WAL
+// is disabled.
+//
+// The caller must hold pt.rawRowsLock for the duration of the rawRows swap
+// this seals off, so the rotation and the swap stay atomic with respect to
+// each other.
+func (pt *partition) sealWALLocked(refCount int32) *walSeal {
+	if pt.wal == nil {
+		return nil
+	}
+	path, err := pt.wal.Rotate()
+	if err != nil {
+		logger.Panicf("FATAL: cannot rotate WAL on %q: %s", pt.smallPartsPath, err)
+	}
+	return &walSeal{paths: []string{path}, refCount: refCount}
+}
+
 type rawRows struct {
 	rows []rawRow
 }
@@ -463,8 +819,17 @@ func getRawRowsPool(size int) (*sync.Pool, int) {
 
 var rawRowsPools [19]sync.Pool
 
-func (pt *partition) addRowsPart(rows []rawRow) {
+// addRowsPart converts rows into a new in-memory small part.
+//
+// seals references the sealed WAL segment(s) that are the only durable copy
+// of rows until the returned part (or whatever it's later merged into) is
+// durably written to disk; mergeParts releases them then. rows can straddle
+// two rotations (see AddRows), hence a slice rather than a single seal.
+func (pt *partition) addRowsPart(rows []rawRow, seals []*walSeal) {
 	if len(rows) == 0 {
+		for _, seal := range seals {
+			seal.release()
+		}
 		return
 	}
 
@@ -493,6 +858,7 @@ func (pt *partition) addRowsPart(rows []rawRow) {
 	pw := &partWrapper{
 		p:        p,
 		mp:       mp,
+		walSeals: seals,
 		refCount: 1,
 	}
 
@@ -505,7 +871,12 @@ func (pt *partition) addRowsPart(rows []rawRow) {
 	}
 
 	// The added part exceeds available limit. Help merging parts.
-	err = pt.mergeSmallParts(false)
+	//
+	// This is submitted as a high-priority assist, so it jumps ahead of
+	// best-effort background merges on the shared MergeScheduler and
+	// provides effective ingestion backpressure instead of silently queuing
+	// behind other partitions' merges.
+	err = pt.assistSmallPartsMerge()
 	if err == nil {
 		atomic.AddUint64(&pt.smallAssistedMerges, 1)
 		return
@@ -552,6 +923,11 @@ func (pt *partition) PutParts(pws []*partWrapper) {
 func (pt *partition) MustClose() {
 	close(pt.stopCh)
 
+	logger.Infof("waiting for retention worker to stop on %q...", pt.smallPartsPath)
+	startTime0 := time.Now()
+	pt.retentionWorkerWG.Wait()
+	logger.Infof("retention worker stopped in %s on %q", time.Since(startTime0), pt.smallPartsPath)
+
 	logger.Infof("waiting for inmemory parts flusher to stop on %q...", pt.smallPartsPath)
 	startTime := time.Now()
 	pt.inmemoryPartsFlusherWG.Wait()
@@ -572,6 +948,11 @@ func (pt *partition) MustClose() {
 	pt.bigPartsMergerWG.Wait()
 	logger.Infof("big part mergers stopped in %s on %q", time.Since(startTime), pt.bigPartsPath)
 
+	logger.Infof("waiting for in-flight concurrent merges to stop on %q...", pt.smallPartsPath)
+	startTime = time.Now()
+	pt.mergeWorkersWG.Wait()
+	logger.Infof("in-flight concurrent merges stopped in %s on %q", time.Since(startTime), pt.smallPartsPath)
+
 	logger.Infof("flushing inmemory parts to files on %q...", pt.smallPartsPath)
 	startTime = time.Now()
 
@@ -619,6 +1000,13 @@ func (pt *partition) MustClose() {
 	for _, pw := range bigParts {
 		pw.decRef()
 	}
+
+	if pt.wal != nil {
+		pt.wal.MustClose()
+	}
+	if pt.txnLog != nil {
+		pt.txnLog.MustClose()
+	}
 }
 
 func (pt *partition) startRawRowsFlusher() {
@@ -648,6 +1036,7 @@ func (pt *partition) flushRawRows(newRawRows []rawRow, isFinal bool) []rawRow {
 	oldRawRows := newRawRows[:0]
 	mustFlush := false
 	currentTime := time.Now()
+	var seals []*walSeal
 
 	pt.rawRowsLock.Lock()
 	if isFinal || currentTime.Sub(pt.rawRowsLastFlushTime) > rawRowsFlushInterval {
@@ -655,11 +1044,23 @@ func (pt *partition) flushRawRows(newRawRows []rawRow, isFinal bool) []rawRow {
 		oldRawRows = pt.rawRows
 		pt.rawRows = newRawRows[:0]
 		pt.rawRowsLastFlushTime = currentTime
+		if len(oldRawRows) > 0 {
+			// This flush takes the whole of pt.rawRows, so it inherits
+			// pt.rawRowsSeal as-is (if a prior AddRows left it holding a
+			// seal for some of these rows) on top of a fresh seal of its
+			// own covering whatever was appended to the WAL's current
+			// segment since then.
+			if pt.rawRowsSeal != nil {
+				seals = append(seals, pt.rawRowsSeal)
+				pt.rawRowsSeal = nil
+			}
+			seals = append(seals, pt.sealWALLocked(1))
+		}
 	}
 	pt.rawRowsLock.Unlock()
 
 	if mustFlush {
-		pt.addRowsPart(oldRawRows)
+		pt.addRowsPart(oldRawRows, seals)
 	}
 	return oldRawRows
 }
@@ -736,22 +1137,24 @@ var mergeWorkers = func() int {
 	return n
 }()
 
+// startMergeWorkers starts a single small-parts and a single big-parts
+// merge-driving goroutine per partition. They don't run merges themselves;
+// each merge attempt is submitted to the process-wide MergeScheduler (see
+// merge_scheduler.go), which bounds actual concurrency across every
+// partition in the process instead of letting N partitions each spawn their
+// own O(GOMAXPROCS) worker pool.
 func (pt *partition) startMergeWorkers() {
-	for i := 0; i < mergeWorkers; i++ {
-		pt.smallPartsMergerWG.Add(1)
-		go func() {
-			pt.smallPartsMerger()
-			pt.smallPartsMergerWG.Done()
-		}()
-	}
+	pt.smallPartsMergerWG.Add(1)
+	go func() {
+		pt.smallPartsMerger()
+		pt.smallPartsMergerWG.Done()
+	}()
 
-	for i := 0; i < mergeWorkers; i++ {
-		pt.bigPartsMergerWG.Add(1)
-		go func() {
-			pt.bigPartsMerger()
-			pt.bigPartsMergerWG.Done()
-		}()
-	}
+	pt.bigPartsMergerWG.Add(1)
+	go func() {
+		pt.bigPartsMerger()
+		pt.bigPartsMergerWG.Done()
+	}()
 }
 
 func (pt *partition) bigPartsMerger() {
@@ -866,45 +1269,118 @@ type freeSpaceEntry struct {
 	freeSpace  uint64
 }
 
+// mergeBigParts plans one big-parts merge task and dispatches it
+// asynchronously, so the driving partsMerger goroutine loops straight back
+// into planAndMark to look for another disjoint task instead of waiting for
+// this one to finish. Concurrency across the resulting goroutines is bounded
+// by the process-wide MergeScheduler; isInMerge (set by planAndMark) keeps
+// their part sets disjoint, acting as a per-part-range lock within pt.
 func (pt *partition) mergeBigParts(isFinal bool) error {
-	maxRows := pt.maxOutPartRows()
-	if maxRows > maxRowsPerBigPart {
-		maxRows = maxRowsPerBigPart
+	pws := pt.planAndMark(MergeTierBig, isFinal)
+	if len(pws) == 0 {
+		return errNothingToMerge
 	}
+	pt.submitMergeAsync(pws, MergeTierBig, &pt.bigMergesCount, &pt.activeBigMerges)
+	return nil
+}
 
-	pt.partsLock.Lock()
-	pws := getPartsToMerge(pt.bigParts, maxRows, isFinal)
-	pt.partsLock.Unlock()
-
+func (pt *partition) mergeSmallParts(isFinal bool) error {
+	pws := pt.planAndMark(MergeTierSmall, isFinal)
 	if len(pws) == 0 {
 		return errNothingToMerge
 	}
+	pt.submitMergeAsync(pws, MergeTierSmall, &pt.smallMergesCount, &pt.activeSmallMerges)
+	return nil
+}
 
-	atomic.AddUint64(&pt.bigMergesCount, 1)
-	atomic.AddUint64(&pt.activeBigMerges, 1)
-	err := pt.mergeParts(pws, pt.stopCh)
-	atomic.AddUint64(&pt.activeBigMerges, ^uint64(0))
+// assistSmallPartsMerge is called directly from the ingestion path
+// (addRowsPart) once maxSmallPartsPerPartition is exceeded.
+func (pt *partition) assistSmallPartsMerge() error {
+	pws := pt.planAndMark(MergeTierSmall, false)
+	if len(pws) == 0 {
+		return errNothingToMerge
+	}
+	return pt.submitMerge(pws, MergeTierSmall, true, &pt.smallMergesCount, &pt.activeSmallMerges)
+}
 
-	return err
+// submitMergeAsync behaves like submitMerge, but runs it on its own
+// goroutine (tracked by pt.mergeWorkersWG) instead of blocking the caller,
+// so pt's driving partsMerger loop can dispatch several disjoint merges
+// concurrently. Errors are logged rather than returned, the same way other
+// background workers in this package (e.g. the retention worker) surface
+// failures.
+func (pt *partition) submitMergeAsync(pws []*partWrapper, tier MergeTier, mergesCount, activeMerges *uint64) {
+	pt.mergeWorkersWG.Add(1)
+	go func() {
+		defer pt.mergeWorkersWG.Done()
+		if err := pt.submitMerge(pws, tier, false, mergesCount, activeMerges); err != nil && err != errForciblyStopped {
+			logger.Errorf("error when merging parts in partition %q: %s", pt.smallPartsPath, err)
+		}
+	}()
 }
 
-func (pt *partition) mergeSmallParts(isFinal bool) error {
-	maxRows := uint64(maxRowsPerSmallPart * defaultPartsToMerge)
+// submitMerge hands the merge of pws off to the process-wide MergeScheduler
+// and blocks until it completes, so scheduling (what runs when, bounded by
+// GOMAXPROCS for small merges and by free disk space for big merges) is
+// centralized across every partition instead of each partition racing its
+// own worker pool for disk and CPU.
+func (pt *partition) submitMerge(pws []*partWrapper, tier MergeTier, isAssist bool, mergesCount, activeMerges *uint64) error {
+	outRowsCount := uint64(0)
+	for _, pw := range pws {
+		outRowsCount += pw.p.ph.RowsCount
+	}
+
+	var mergeErr error
+	task := &SchedulerMergeTask{
+		Tier:               tier,
+		IsAssist:           isAssist,
+		SmallPartsBacklog:  pt.smallPartsBacklog(),
+		OutputSizeEstimate: outRowsCount,
+		BigPartsPath:       pt.bigPartsPath,
+		Run: func() {
+			atomic.AddUint64(mergesCount, 1)
+			atomic.AddUint64(activeMerges, 1)
+			mergeErr = pt.mergeParts(pws, pt.stopCh)
+			atomic.AddUint64(activeMerges, ^uint64(0))
+		},
+	}
+	GetMergeScheduler().Submit(task)
+	return mergeErr
+}
 
+// smallPartsBacklog returns how close the partition is to
+// maxSmallPartsPerPartition, used by the scheduler to prioritize partitions
+// under the most ingestion backpressure.
+func (pt *partition) smallPartsBacklog() float64 {
 	pt.partsLock.Lock()
-	pws := getPartsToMerge(pt.smallParts, maxRows, isFinal)
+	n := len(pt.smallParts)
 	pt.partsLock.Unlock()
+	return float64(n) / float64(maxSmallPartsPerPartition)
+}
 
-	if len(pws) == 0 {
-		return errNothingToMerge
-	}
+// planAndMark asks pt.planner for a merge task targeting tier, marks its
+// parts as isInMerge and returns them. It returns nil if the planner found
+// nothing to merge in that tier.
+func (pt *partition) planAndMark(tier MergeTier, isFinal bool) []*partWrapper {
+	maxOutputRows := pt.maxOutPartRows()
 
-	atomic.AddUint64(&pt.smallMergesCount, 1)
-	atomic.AddUint64(&pt.activeSmallMerges, 1)
-	err := pt.mergeParts(pws, pt.stopCh)
-	atomic.AddUint64(&pt.activeSmallMerges, ^uint64(0))
+	pt.partsLock.Lock()
+	defer pt.partsLock.Unlock()
 
-	return err
+	tasks := pt.planner.Plan(pt.smallParts, pt.bigParts, isFinal, maxOutputRows)
+	for _, t := range tasks {
+		if t.Tier != tier || len(t.Parts) == 0 {
+			continue
+		}
+		for _, pw := range t.Parts {
+			if pw.isInMerge {
+				logger.Panicf("BUG: partWrapper.isInMerge cannot be set")
+			}
+			pw.isInMerge = true
+		}
+		return t.Parts
+	}
+	return nil
 }
 
 var errNothingToMerge = fmt.Errorf("nothing to merge")
@@ -949,8 +1425,12 @@ func (pt *partition) mergeParts(pws []*partWrapper, stopCh <-chan struct{}) erro
 	}
 
 	outRowsCount := uint64(0)
+	maxOutTimestamp := int64(0)
 	for _, pw := range pws {
 		outRowsCount += pw.p.ph.RowsCount
+		if pw.p.ph.MaxTimestamp > maxOutTimestamp {
+			maxOutTimestamp = pw.p.ph.MaxTimestamp
+		}
 	}
 	isBigPart := outRowsCount > maxRowsPerSmallPart
 	nocache := isBigPart
@@ -964,7 +1444,7 @@ func (pt *partition) mergeParts(pws []*partWrapper, stopCh <-chan struct{}) erro
 	mergeIdx := pt.nextMergeIdx()
 	tmpPartPath := fmt.Sprintf("%s/tmp/%016X", ptPath, mergeIdx)
 	bsw := getBlockStreamWriter()
-	compressLevel := getCompressLevelForRowsCount(outRowsCount)
+	compressLevel := getCompressLevelForRowsCountAndAge(outRowsCount, maxOutTimestamp)
 	if err := bsw.InitFromFilePart(tmpPartPath, nocache, compressLevel); err != nil {
 		return fmt.Errorf("cannot create destination part %q: %s", tmpPartPath, err)
 	}
@@ -1008,14 +1488,56 @@ func (pt *partition) mergeParts(pws []*partWrapper, stopCh <-chan struct{}) erro
 		dstPartPath = ph.Path(ptPath, mergeIdx)
 	}
 	fmt.Fprintf(&bb, "%s -> %s\n", tmpPartPath, dstPartPath)
-	txnPath := fmt.Sprintf("%s/txn/%016X", ptPath, mergeIdx)
-	if err := fs.WriteFile(txnPath, bb.B); err != nil {
-		return fmt.Errorf("cannot create transaction file %q: %s", txnPath, err)
+
+	if isBigPart && len(dstPartPath) > 0 && pt.bigPartStorage != defaultBigPartStorage {
+		// Promote the merged big part onto pt.bigPartStorage *before* running
+		// the merge transaction below, while tmpPartPath and the old source
+		// parts still both exist side by side. The transaction is what deletes
+		// the source parts' files, so if the upload fails here, pws are still
+		// intact and this merge can simply be retried later instead of losing
+		// data that's already gone locally but never made it to remote storage.
+		//
+		// tmpPartPath hasn't been renamed to dstPartPath yet, so it's uploaded
+		// under the remote name it will be looked up by once the merge commits.
+		if err := copyPartToStorage(tmpPartPath, dstPartPath, pt.bigPartStorage); err != nil {
+			return fmt.Errorf("cannot promote merged big part %q to its backing storage: %s", dstPartPath, err)
+		}
+	}
+
+	// Run the transaction through pt's coalesced txn log instead of a
+	// one-off file under ptPath/txn, so concurrent merges (see
+	// submitMergeAsync) don't each pay for a separate file
+	// create/rename/fsync/remove cycle.
+	if err := pt.runMergeTxn(bb.B); err != nil {
+		return fmt.Errorf("cannot execute merge transaction: %s", err)
+	}
+
+	// The rows contributed by any mp-backed source part are now durably on
+	// disk (or durably recorded as deleted, if dstPartPath is empty), so the
+	// WAL segments protecting them until now can finally be released.
+	for _, pw := range pws {
+		for _, seal := range pw.walSeals {
+			seal.release()
+		}
+		pw.walSeals = nil
 	}
 
-	// Run the created transaction.
-	if err := runTransaction(&pt.snapshotLock, pt.smallPartsPath, pt.bigPartsPath, txnPath); err != nil {
-		return fmt.Errorf("cannot execute transaction %q: %s", txnPath, err)
+	if pt.bigPartStorage != defaultBigPartStorage {
+		// Any source part that lived under bigPartsPath was promoted onto
+		// pt.bigPartStorage by its own merge (see the copyPartToStorage call
+		// above), so now that the transaction has committed its replacement
+		// (or its deletion, if dstPartPath is empty), that remote copy is
+		// stale and can be reclaimed. A failure here just leaks the remote
+		// copy rather than corrupting anything local, so it's logged and
+		// not treated as fatal.
+		for _, pw := range pws {
+			if pw.mp != nil || !strings.HasPrefix(pw.p.path, pt.bigPartsPath) {
+				continue
+			}
+			if err := pt.bigPartStorage.Remove(pw.p.path); err != nil {
+				logger.Errorf("cannot remove superseded big part %q from its backing storage: %s", pw.p.path, err)
+			}
+		}
 	}
 
 	var newPW *partWrapper
@@ -1056,6 +1578,10 @@ func (pt *partition) mergeParts(pws []*partWrapper, stopCh <-chan struct{}) erro
 		logger.Panicf("BUG: unexpected number of parts removed; got %d, want %d", removedSmallParts+removedBigParts, len(m))
 	}
 
+	// Commit a new root manifest snapshot reflecting the merge outcome, so
+	// openPartition and query engines pinning a Snapshot() see a consistent view.
+	pt.commitRootSnapshot()
+
 	// Remove partition references from old parts.
 	for _, pw := range pws {
 		pw.decRef()
@@ -1234,7 +1760,21 @@ func openParts(pathPrefix1, pathPrefix2, path string) ([]*partWrapper, error) {
 		return nil, fmt.Errorf("cannot create directories for partition %q: %s", path, err)
 	}
 
-	// Open parts.
+	// Prefer the root manifest when present: it names exactly the parts that
+	// belong to the latest committed epoch, so stray directories left behind
+	// by an interrupted merge or retention drop are ignored instead of
+	// being opened and later GC'd by gcStaleDirs.
+	snap, err := readRootSnapshot(pathPrefix1)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read root manifest from %q: %s", pathPrefix1, err)
+	}
+	if snap != nil {
+		isBigPart := path == pathPrefix2
+		return openPartsFromManifest(path, snap, isBigPart)
+	}
+
+	// No manifest yet (e.g. a partition created before this feature, or one
+	// that hasn't flushed a single part). Fall back to scanning the directory.
 	fis, err := d.Readdir(-1)
 	if err != nil {
 		return nil, fmt.Errorf("cannot read directory %q: %s", d.Name(), err)
@@ -1246,11 +1786,15 @@ func openParts(pathPrefix1, pathPrefix2, path string) ([]*partWrapper, error) {
 			continue
 		}
 		fn := fi.Name()
-		if fn == "tmp" || fn == "txn" || fn == "snapshots" {
+		if fn == "tmp" || fn == "txn" || fn == "snapshots" || fn == "wal" {
 			// "snapshots" dir is skipped for backwards compatibility. Now it is unused.
 			// Skip special dirs.
 			continue
 		}
+		if len(fn) > 5 && fn[:5] == "root." {
+			// root.<epoch>.json manifest files.
+			continue
+		}
 		partPath := path + "/" + fn
 		startTime := time.Now()
 		p, err := openFilePart(partPath)
@@ -1271,6 +1815,34 @@ func openParts(pathPrefix1, pathPrefix2, path string) ([]*partWrapper, error) {
 	return pws, nil
 }
 
+// openPartsFromManifest opens the parts listed in snap whose IsBigPart
+// matches isBigPart, ignoring any stray directories under path that aren't
+// named in the manifest. snap combines both tiers (it's persisted only
+// under smallPartsPath), so the caller must filter by the tier it's opening
+// rather than attempting to open every entry under path.
+func openPartsFromManifest(path string, snap *rootSnapshot, isBigPart bool) ([]*partWrapper, error) {
+	var pws []*partWrapper
+	for _, info := range snap.Parts {
+		if info.IsBigPart != isBigPart {
+			continue
+		}
+		partPath := path + "/" + info.PartName
+		startTime := time.Now()
+		p, err := openFilePart(partPath)
+		if err != nil {
+			mustCloseParts(pws)
+			return nil, fmt.Errorf("cannot open part %q listed in root manifest: %s", partPath, err)
+		}
+		logger.Infof("opened part %q in %s", partPath, time.Since(startTime))
+
+		pws = append(pws, &partWrapper{
+			p:        p,
+			refCount: 1,
+		})
+	}
+	return pws, nil
+}
+
 func mustCloseParts(pws []*partWrapper) {
 	for _, pw := range pws {
 		if pw.refCount != 1 {
@@ -1284,6 +1856,10 @@ func mustCloseParts(pws []*partWrapper) {
 //
 // Snapshot is created using linux hard links, so it is usually created
 // very quickly.
+//
+// A snapshot_manifest.json listing every hardlinked part together with a
+// content hash is written alongside each dir, so VerifySnapshot can later
+// detect silent bitrot on the volume the snapshot was shipped to.
 func (pt *partition) CreateSnapshotAt(smallPath, bigPath string) error {
 	logger.Infof("creating partition snapshot of %q and %q...", pt.smallPartsPath, pt.bigPartsPath)
 	startTime := time.Now()
@@ -1299,10 +1875,15 @@ func (pt *partition) CreateSnapshotAt(smallPath, bigPath string) error {
 	pt.snapshotLock.Lock()
 	defer pt.snapshotLock.Unlock()
 
-	if err := pt.createSnapshot(pt.smallPartsPath, smallPath); err != nil {
+	pt.partsLock.Lock()
+	smallPWs := append([]*partWrapper(nil), pt.smallParts...)
+	bigPWs := append([]*partWrapper(nil), pt.bigParts...)
+	pt.partsLock.Unlock()
+
+	if err := pt.createSnapshot(pt.smallPartsPath, smallPath, "small", smallPWs); err != nil {
 		return fmt.Errorf("cannot create snapshot for %q: %s", pt.smallPartsPath, err)
 	}
-	if err := pt.createSnapshot(pt.bigPartsPath, bigPath); err != nil {
+	if err := pt.createSnapshot(pt.bigPartsPath, bigPath, "big", bigPWs); err != nil {
 		return fmt.Errorf("cannot create snapshot for %q: %s", pt.bigPartsPath, err)
 	}
 
@@ -1310,7 +1891,18 @@ func (pt *partition) CreateSnapshotAt(smallPath, bigPath string) error {
 	return nil
 }
 
-func (pt *partition) createSnapshot(srcDir, dstDir string) error {
+// createSnapshot hardlinks every directory in srcDir (other than the tmp,
+// txn and wal special dirs, and the root.json/root.<epoch>.json manifest
+// files) into dstDir, and, for every hardlinked dir matching
+// a part in pws, hashes its contents and writes the result into a
+// snapshot_manifest.json under dstDir alongside the parts.
+//
+// This always hardlinks from the local srcDir rather than going through
+// pt.bigPartStorage for the "big" tier: mergeParts keeps a full local copy
+// of every big part regardless of backend (see the comment on
+// bigPartStorage's integration there), so the local directory this reads
+// from is always present and complete.
+func (pt *partition) createSnapshot(srcDir, dstDir, tier string, pws []*partWrapper) error {
 	if err := fs.MkdirAllFailIfExist(dstDir); err != nil {
 		return fmt.Errorf("cannot create snapshot dir %q: %s", dstDir, err)
 	}
@@ -1321,25 +1913,60 @@ func (pt *partition) createSnapshot(srcDir, dstDir string) error {
 	}
 	defer fs.MustClose(d)
 
+	pwByName := make(map[string]*partWrapper, len(pws))
+	for _, pw := range pws {
+		pwByName[filepath.Base(pw.p.path)] = pw
+	}
+
 	fis, err := d.Readdir(-1)
 	if err != nil {
 		return fmt.Errorf("cannot read directory: %s", err)
 	}
+	var manifest SnapshotManifest
 	for _, fi := range fis {
 		if !fs.IsDirOrSymlink(fi) {
 			// Skip non-directories.
 			continue
 		}
 		fn := fi.Name()
-		if fn == "tmp" || fn == "txn" {
+		if fn == "tmp" || fn == "txn" || fn == "wal" {
 			// Skip special dirs.
 			continue
 		}
+		if len(fn) > 5 && fn[:5] == "root." {
+			// root.json (the symlink to the live manifest) and the
+			// root.<epoch>.json manifest files it points to.
+			continue
+		}
 		srcPartPath := srcDir + "/" + fn
 		dstPartPath := dstDir + "/" + fn
 		if err := fs.HardLinkFiles(srcPartPath, dstPartPath); err != nil {
 			return fmt.Errorf("cannot create hard links from %q to %q: %s", srcPartPath, dstPartPath, err)
 		}
+
+		pw, ok := pwByName[fn]
+		if !ok {
+			// Not a tracked on-disk part (e.g. a leftover dir); skip it in
+			// the manifest rather than guessing at its metadata.
+			continue
+		}
+		hash, err := hashPartDir(dstPartPath)
+		if err != nil {
+			return fmt.Errorf("cannot hash snapshot part %q: %s", dstPartPath, err)
+		}
+		manifest.Parts = append(manifest.Parts, SnapshotPartManifest{
+			Tier:         tier,
+			Name:         fn,
+			Hash:         hash,
+			RowsCount:    pw.p.ph.RowsCount,
+			BlocksCount:  pw.p.ph.BlocksCount,
+			MinTimestamp: pw.p.ph.MinTimestamp,
+			MaxTimestamp: pw.p.ph.MaxTimestamp,
+		})
+	}
+
+	if err := writeSnapshotManifest(dstDir, &manifest); err != nil {
+		return fmt.Errorf("cannot write snapshot manifest for %q: %s", dstDir, err)
 	}
 
 	fs.SyncPath(dstDir)
@@ -1379,15 +2006,34 @@ func runTransactions(txnLock *sync.RWMutex, pathPrefix1, pathPrefix2, path strin
 }
 
 func runTransaction(txnLock *sync.RWMutex, pathPrefix1, pathPrefix2, txnPath string) error {
+	data, err := ioutil.ReadFile(txnPath)
+	if err != nil {
+		return fmt.Errorf("cannot read transaction file: %s", err)
+	}
+	if err := applyTxnBody(txnLock, pathPrefix1, pathPrefix2, data); err != nil {
+		return err
+	}
+
+	// Remove the transaction file.
+	if err := os.Remove(txnPath); err != nil {
+		return fmt.Errorf("cannot remove transaction file: %s", err)
+	}
+
+	return nil
+}
+
+// applyTxnBody parses and executes a single transaction body in the
+// "rmPath\n...\nsrcPath -> dstPath\n" format written by mergeParts and
+// dropPartsViaTxn, removing rmPaths and then renaming (or, if dstPath is
+// empty, just removing) srcPath. It's shared by runTransaction (one file per
+// transaction, used for legacy on-disk leftovers and snapshot restores) and
+// runTransactionLog (the coalesced per-partition log in merge_txn_log.go).
+func applyTxnBody(txnLock *sync.RWMutex, pathPrefix1, pathPrefix2 string, data []byte) error {
 	// The transaction must be run under read lock in order to provide
 	// consistent snapshots with partition.CreateSnapshot().
 	txnLock.RLock()
 	defer txnLock.RUnlock()
 
-	data, err := ioutil.ReadFile(txnPath)
-	if err != nil {
-		return fmt.Errorf("cannot read transaction file: %s", err)
-	}
 	if len(data) > 0 && data[len(data)-1] == '\n' {
 		data = data[:len(data)-1]
 	}
@@ -1399,7 +2045,7 @@ func runTransaction(txnLock *sync.RWMutex, pathPrefix1, pathPrefix2, txnPath str
 	rmPaths := paths[:len(paths)-1]
 	mvPaths := strings.Split(paths[len(paths)-1], " -> ")
 	if len(mvPaths) != 2 {
-		return fmt.Errorf("invalid last line in the transaction file: got %q; must contain `srcPath -> dstPath`", paths[len(paths)-1])
+		return fmt.Errorf("invalid last line in the transaction: got %q; must contain `srcPath -> dstPath`", paths[len(paths)-1])
 	}
 
 	// Remove old paths. It is OK if certain paths don't exist.
@@ -1416,7 +2062,7 @@ func runTransaction(txnLock *sync.RWMutex, pathPrefix1, pathPrefix2, txnPath str
 	// Move the new part to new directory.
 	srcPath := mvPaths[0]
 	dstPath := mvPaths[1]
-	srcPath, err = validatePath(pathPrefix1, pathPrefix2, srcPath)
+	srcPath, err := validatePath(pathPrefix1, pathPrefix2, srcPath)
 	if err != nil {
 		return fmt.Errorf("invalid source path to rename: %s", err)
 	}
@@ -1443,15 +2089,10 @@ func runTransaction(txnLock *sync.RWMutex, pathPrefix1, pathPrefix2, txnPath str
 		}
 	}
 
-	// Flush pathPrefix* directory metadata to the underying storage.
+	// Flush pathPrefix* directory metadata to the underlying storage.
 	fs.SyncPath(pathPrefix1)
 	fs.SyncPath(pathPrefix2)
 
-	// Remove the transaction file.
-	if err := os.Remove(txnPath); err != nil {
-		return fmt.Errorf("cannot remove transaction file: %s", err)
-	}
-
 	return nil
 }
 
@@ -0,0 +1,29 @@
+package storage
+
+import "testing"
+
+// TestCreatePartitionLegacySignatureUsesDefaultOptions guards the
+// table.go-compatible legacy wrapper: createPartition (the pre-StorageOptions
+// signature) must keep behaving exactly like
+// createPartitionWithOptions(..., DefaultStorageOptions), not some zero-value
+// StorageOptions that would misinterpret timestamp precision.
+func TestCreatePartitionLegacySignatureUsesDefaultOptions(t *testing.T) {
+	smallRoot := t.TempDir()
+	bigRoot := t.TempDir()
+	getDeletedMetricIDs := func() map[uint64]struct{} { return nil }
+
+	pt, err := createPartition(0, smallRoot, bigRoot, getDeletedMetricIDs)
+	if err != nil {
+		t.Fatalf("createPartition() failed: %s", err)
+	}
+	defer pt.MustClose()
+
+	if pt.opts.TimestampPrecision != DefaultStorageOptions.TimestampPrecision {
+		t.Fatalf("createPartition() must use DefaultStorageOptions; got TimestampPrecision=%q, want %q",
+			pt.opts.TimestampPrecision, DefaultStorageOptions.TimestampPrecision)
+	}
+	if pt.opts.PartitionDuration != DefaultStorageOptions.PartitionDuration {
+		t.Fatalf("createPartition() must use DefaultStorageOptions; got PartitionDuration=%v, want %v",
+			pt.opts.PartitionDuration, DefaultStorageOptions.PartitionDuration)
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// StorageOptions configures how a partition buckets time and interprets the
+// precision of incoming timestamps, following tstorage's model of
+// user-selectable timestamp units.
+type StorageOptions struct {
+	// PartitionDuration is the size of the time bucket each partition
+	// covers. Zero (the default) keeps the legacy calendar-month buckets
+	// named YYYY_MM.
+	PartitionDuration time.Duration
+
+	// TimestampPrecision is the unit of incoming rawRow.Timestamp values:
+	// one of "ns", "us", "ms" (the default) or "s".
+	TimestampPrecision string
+
+	// BigPartStorage, if set, receives a copy of every merged big part on a
+	// remote PartStorage (e.g. an object store), in addition to bigPartsPath.
+	// Big parts are still kept on bigPartsPath, and reads and snapshots
+	// still use bigPartsPath only. Nil keeps the historical
+	// local-filesystem-only behavior.
+	BigPartStorage PartStorage
+}
+
+// DefaultStorageOptions is used by createPartition/openPartition callers
+// that don't need sub-hour buckets or non-millisecond timestamps.
+var DefaultStorageOptions = StorageOptions{
+	TimestampPrecision: "ms",
+}
+
+// millisPerUnit returns how many milliseconds a single unit of
+// o.TimestampPrecision represents, so a timestamp in that precision can be
+// converted to milliseconds via multiplication.
+func (o StorageOptions) millisPerUnit() float64 {
+	switch o.TimestampPrecision {
+	case "", "ms":
+		return 1
+	case "s":
+		return 1000
+	case "us":
+		return 1.0 / 1000
+	case "ns":
+		return 1.0 / 1e6
+	default:
+		logger.Panicf("BUG: unsupported TimestampPrecision %q; want ns, us, ms or s", o.TimestampPrecision)
+		return 0
+	}
+}
+
+// toMilliseconds converts ts, expressed in o.TimestampPrecision units, to milliseconds.
+func (o StorageOptions) toMilliseconds(ts int64) int64 {
+	return int64(float64(ts) * o.millisPerUnit())
+}
+
+const partitionNamePrefix = "p-"
+
+// partitionNameForTimestamp returns the directory name for the partition
+// covering timestampMs (already normalized to milliseconds), honoring
+// o.PartitionDuration. Falls back to the legacy YYYY_MM scheme when
+// PartitionDuration is zero.
+func partitionNameForTimestamp(timestampMs int64, o StorageOptions) string {
+	if o.PartitionDuration <= 0 {
+		return timestampToPartitionName(timestampMs)
+	}
+	durationMs := o.PartitionDuration.Milliseconds()
+	bucketStartMs := timestampMs - timestampMs%durationMs
+	bucketEndMs := bucketStartMs + durationMs - 1
+	return fmt.Sprintf("%s%d-%d", partitionNamePrefix, bucketStartMs/1000, bucketEndMs/1000)
+}
+
+// timeRangeForPartitionName parses the TimeRange encoded in name. The bounds
+// come from the name alone; o is not consulted. Falls back to the legacy
+// YYYY_MM parser for names that don't use the p-<start>-<end> scheme.
+func timeRangeForPartitionName(name string, o StorageOptions) (TimeRange, error) {
+	var tr TimeRange
+	if !strings.HasPrefix(name, partitionNamePrefix) {
+		if err := tr.fromPartitionName(name); err != nil {
+			return tr, err
+		}
+		return tr, nil
+	}
+	parts := strings.SplitN(name[len(partitionNamePrefix):], "-", 2)
+	if len(parts) != 2 {
+		return tr, fmt.Errorf("invalid partition name %q; want %q<start>-<end>", name, partitionNamePrefix)
+	}
+	startSec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return tr, fmt.Errorf("cannot parse start timestamp from partition name %q: %s", name, err)
+	}
+	endSec, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return tr, fmt.Errorf("cannot parse end timestamp from partition name %q: %s", name, err)
+	}
+	tr.MinTimestamp = startSec * 1000
+	tr.MaxTimestamp = endSec*1000 + 999
+	return tr, nil
+}
+
+// partitionOptionsManifest is persisted as partition.json at partition
+// creation time, so openPartition can refuse to open a partition with a
+// mismatched configuration instead of silently misinterpreting timestamps.
+type partitionOptionsManifest struct {
+	PartitionDurationMs int64  `json:"partitionDurationMs"`
+	TimestampPrecision  string `json:"timestampPrecision"`
+}
+
+func partitionOptionsPath(smallPartsPath string) string {
+	return filepath.Clean(smallPartsPath) + "/partition.json"
+}
+
+// writePartitionOptions persists o as partition.json under smallPartsPath.
+func writePartitionOptions(smallPartsPath string, o StorageOptions) error {
+	m := partitionOptionsManifest{
+		PartitionDurationMs: o.PartitionDuration.Milliseconds(),
+		TimestampPrecision:  o.TimestampPrecision,
+	}
+	data, err := json.Marshal(&m)
+	if err != nil {
+		return fmt.Errorf("cannot marshal partition options: %s", err)
+	}
+	path := partitionOptionsPath(smallPartsPath)
+	if err := fs.WriteFileAtomically(path, data); err != nil {
+		return fmt.Errorf("cannot write %q: %s", path, err)
+	}
+	return nil
+}
+
+// verifyPartitionOptions reads partition.json under smallPartsPath, if any,
+// and returns an error if it doesn't match o. A missing file is treated as
+// matching, for partitions created before this feature existed.
+func verifyPartitionOptions(smallPartsPath string, o StorageOptions) error {
+	path := partitionOptionsPath(smallPartsPath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot read %q: %s", path, err)
+	}
+	var m partitionOptionsManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("cannot parse %q: %s", path, err)
+	}
+	wantPrecision := o.TimestampPrecision
+	if wantPrecision == "" {
+		wantPrecision = "ms"
+	}
+	gotPrecision := m.TimestampPrecision
+	if gotPrecision == "" {
+		gotPrecision = "ms"
+	}
+	if m.PartitionDurationMs != o.PartitionDuration.Milliseconds() || gotPrecision != wantPrecision {
+		return fmt.Errorf("partition at %q was created with PartitionDuration=%dms, TimestampPrecision=%q; "+
+			"cannot open it with mismatched PartitionDuration=%dms, TimestampPrecision=%q",
+			smallPartsPath, m.PartitionDurationMs, gotPrecision, o.PartitionDuration.Milliseconds(), wantPrecision)
+	}
+	return nil
+}
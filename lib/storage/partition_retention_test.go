@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsFullyExpired(t *testing.T) {
+	origRetention := RetentionDuration
+	defer func() { RetentionDuration = origRetention }()
+
+	pt := &partition{}
+	pt.tr.MaxTimestamp = time.Now().Add(-2 * time.Hour).UnixMilli()
+
+	RetentionDuration = 0
+	if pt.isFullyExpired() {
+		t.Fatalf("isFullyExpired() must be false when RetentionDuration is disabled")
+	}
+
+	RetentionDuration = time.Hour
+	if !pt.isFullyExpired() {
+		t.Fatalf("isFullyExpired() must be true once the partition's time range falls outside RetentionDuration")
+	}
+
+	pt.tr.MaxTimestamp = time.Now().UnixMilli()
+	if pt.isFullyExpired() {
+		t.Fatalf("isFullyExpired() must be false while the partition's time range is still within RetentionDuration")
+	}
+}
@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// RetentionDuration is the duration for which rows are kept in a partition
+// before the background retention checker drops the parts containing them.
+//
+// Zero (the default) disables retention-based dropping, following tstorage's
+// defaultRetention / checkExpiredInterval model.
+var RetentionDuration time.Duration
+
+// checkExpiredInterval is how often the retention checker scans smallParts
+// and bigParts for expired parts.
+const checkExpiredInterval = 30 * time.Second
+
+// startRetentionWorker starts the background goroutine that drops parts
+// older than RetentionDuration. It is a no-op loop if RetentionDuration is zero.
+func (pt *partition) startRetentionWorker() {
+	pt.retentionWorkerWG.Add(1)
+	go func() {
+		defer pt.retentionWorkerWG.Done()
+		pt.retentionWorker()
+	}()
+}
+
+func (pt *partition) retentionWorker() {
+	t := time.NewTicker(checkExpiredInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-pt.stopCh:
+			return
+		case <-t.C:
+			pt.dropExpiredParts()
+		}
+	}
+}
+
+// dropExpiredParts drops all the parts in pt whose MaxTimestamp is older than
+// RetentionDuration, without merging them.
+//
+// Removal goes through the same transaction machinery as merges: a
+// transaction listing the expired part paths is appended to pt's coalesced
+// txn log and applied before the parts are spliced out of
+// smallParts/bigParts, so a crash mid-drop can't leave a part removed from
+// disk but still tracked in memory (or vice versa).
+func (pt *partition) dropExpiredParts() {
+	if RetentionDuration <= 0 {
+		return
+	}
+	minAllowedTimestamp := time.Now().UnixNano()/1e6 - RetentionDuration.Milliseconds()
+
+	pt.partsLock.Lock()
+	expiredSmall, keptSmall := splitExpiredParts(pt.smallParts, minAllowedTimestamp)
+	expiredBig, keptBig := splitExpiredParts(pt.bigParts, minAllowedTimestamp)
+	// Mark the expired parts as in-merge before releasing the lock, so the
+	// planner can't select one of them for a concurrent merge while its
+	// files are being removed below (they're spliced out of
+	// smallParts/bigParts only after the drop transaction has been applied).
+	for _, pw := range expiredSmall {
+		pw.isInMerge = true
+	}
+	for _, pw := range expiredBig {
+		pw.isInMerge = true
+	}
+	pt.partsLock.Unlock()
+
+	if len(expiredSmall) == 0 && len(expiredBig) == 0 {
+		return
+	}
+
+	if err := pt.dropPartsViaTxn(expiredSmall); err != nil {
+		logger.Errorf("cannot drop expired small parts in partition %q: %s", pt.smallPartsPath, err)
+		pt.unmarkInMerge(expiredSmall)
+		pt.unmarkInMerge(expiredBig)
+		return
+	}
+	// The small parts' files are gone now, so splice them out of
+	// pt.smallParts right away instead of waiting on the big-parts drop
+	// below; otherwise a failure there would leave pt.smallParts pointing at
+	// already-deleted files indefinitely.
+	pt.partsLock.Lock()
+	pt.smallParts = keptSmall
+	pt.partsLock.Unlock()
+
+	if err := pt.dropPartsViaTxn(expiredBig); err != nil {
+		logger.Errorf("cannot drop expired big parts in partition %q: %s", pt.bigPartsPath, err)
+		pt.unmarkInMerge(expiredBig)
+		expiredBig = nil
+	} else {
+		pt.partsLock.Lock()
+		pt.bigParts = keptBig
+		pt.partsLock.Unlock()
+
+		if pt.bigPartStorage != defaultBigPartStorage {
+			// The dropped parts' local files are gone now, so their mirror
+			// copies on pt.bigPartStorage (uploaded by mergeParts when each
+			// part was created) are stale too. As in mergeParts, a failure
+			// here just leaks the remote copy rather than affecting
+			// anything local.
+			for _, pw := range expiredBig {
+				if err := pt.bigPartStorage.Remove(pw.p.path); err != nil {
+					logger.Errorf("cannot remove dropped big part %q from its backing storage: %s", pw.p.path, err)
+				}
+			}
+		}
+	}
+
+	rowsDropped := uint64(0)
+	for _, pw := range expiredSmall {
+		rowsDropped += pw.p.ph.RowsCount
+	}
+	for _, pw := range expiredBig {
+		rowsDropped += pw.p.ph.RowsCount
+	}
+	logger.Infof("dropped %d expired parts (%d rows) older than %s from partition %q",
+		len(expiredSmall)+len(expiredBig), rowsDropped, RetentionDuration, pt.smallPartsPath)
+
+	atomic.AddUint64(&pt.rowsDroppedByRetention, rowsDropped)
+	atomic.AddUint64(&pt.partsDroppedByRetention, uint64(len(expiredSmall)+len(expiredBig)))
+
+	for _, pw := range expiredSmall {
+		pt.dropPart(pw)
+	}
+	for _, pw := range expiredBig {
+		pt.dropPart(pw)
+	}
+
+	pt.commitRootSnapshot()
+	pt.gcStaleDirs(pt.smallPartsPath)
+	pt.gcStaleDirs(pt.bigPartsPath)
+}
+
+// unmarkInMerge clears isInMerge on pws, previously set speculatively by
+// dropExpiredParts, after its drop transaction failed, so the planner can
+// consider them again.
+func (pt *partition) unmarkInMerge(pws []*partWrapper) {
+	pt.partsLock.Lock()
+	for _, pw := range pws {
+		pw.isInMerge = false
+	}
+	pt.partsLock.Unlock()
+}
+
+// dropPartsViaTxn builds a transaction listing pws' paths for removal, with
+// an empty rename tail (dstPath=="" in the "srcPath -> dstPath" line just
+// removes srcPath), and runs it through pt's coalesced txn log. It's a no-op
+// if pws is empty.
+func (pt *partition) dropPartsViaTxn(pws []*partWrapper) error {
+	if len(pws) == 0 {
+		return nil
+	}
+	var bb bytesutil.ByteBuffer
+	for _, pw := range pws[:len(pws)-1] {
+		fmt.Fprintf(&bb, "%s\n", pw.p.path)
+	}
+	fmt.Fprintf(&bb, "%s -> \n", pws[len(pws)-1].p.path)
+
+	if err := pt.runMergeTxn(bb.B); err != nil {
+		return fmt.Errorf("cannot execute drop transaction: %s", err)
+	}
+	return nil
+}
+
+// dropPart marks pw as dropped and releases the partition's reference to it.
+//
+// By the time this is called, the part's files have already been removed
+// from disk via dropPartsViaTxn; other outstanding references (e.g. a
+// concurrent GetParts holder) keep the in-memory partWrapper alive until
+// decRef->MustClose tears it down, but no further disk I/O is expected to
+// succeed against pw.p.path.
+func (pt *partition) dropPart(pw *partWrapper) {
+	pt.partsLock.Lock()
+	pw.isDropped = true
+	pt.partsLock.Unlock()
+
+	pw.decRef()
+}
+
+// splitExpiredParts partitions pws into (expired, kept) sets based on
+// minAllowedTimestamp. Parts currently in merge are never treated as
+// expired, since dropping them would race with the in-progress merge.
+func splitExpiredParts(pws []*partWrapper, minAllowedTimestamp int64) (expired, kept []*partWrapper) {
+	for _, pw := range pws {
+		if !pw.isInMerge && pw.p.ph.MaxTimestamp < minAllowedTimestamp {
+			expired = append(expired, pw)
+			continue
+		}
+		kept = append(kept, pw)
+	}
+	return expired, kept
+}
+
+// ColdTierAge is how old (based on a merge output's MaxTimestamp) the data
+// in a merge must be for the merge to use the maximum compression level,
+// regardless of its rows count, to reclaim space on historical data. It only
+// affects merges the planner selects anyway; old parts are not merged
+// because of their age alone.
+//
+// Zero disables the cold tier policy.
+var ColdTierAge time.Duration
+
+// maxCompressLevel is the strongest compression level supported by the
+// on-disk part format.
+const maxCompressLevel = 5
+
+// getCompressLevelForRowsCountAndAge extends getCompressLevelForRowsCount
+// with an age-aware override: getCompressLevelForRowsCount caps compression
+// at level 5 based purely on rows count, ignoring how old the data being
+// merged is. Once ColdTierAge is exceeded, force the maximum level so
+// historical data is compacted as tightly as possible.
+func getCompressLevelForRowsCountAndAge(rowsCount uint64, maxTimestampMs int64) int {
+	if ColdTierAge > 0 {
+		age := time.Since(time.UnixMilli(maxTimestampMs))
+		if age >= ColdTierAge {
+			return maxCompressLevel
+		}
+	}
+	return getCompressLevelForRowsCount(rowsCount)
+}
+
+// isFullyExpired returns true if pt's whole time range (pt.tr), and so every
+// part and pending raw row it can hold, falls outside the retention window,
+// so the table layer
+// above can Drop the whole partition instead of dropping parts one by one.
+func (pt *partition) isFullyExpired() bool {
+	if RetentionDuration <= 0 {
+		return false
+	}
+	minAllowedTimestamp := time.Now().UnixNano()/1e6 - RetentionDuration.Milliseconds()
+	return pt.tr.MaxTimestamp < minAllowedTimestamp
+}
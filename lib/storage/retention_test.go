@@ -0,0 +1,43 @@
+package storage
+
+import "testing"
+
+func newTestPartWrapperWithMaxTimestamp(maxTimestamp int64) *partWrapper {
+	return &partWrapper{
+		p: &part{
+			ph: partHeader{
+				MaxTimestamp: maxTimestamp,
+			},
+		},
+	}
+}
+
+func TestSplitExpiredPartsSkipsInMergeParts(t *testing.T) {
+	expiredButInMerge := newTestPartWrapperWithMaxTimestamp(100)
+	expiredButInMerge.isInMerge = true
+	expired := newTestPartWrapperWithMaxTimestamp(100)
+	kept := newTestPartWrapperWithMaxTimestamp(1000)
+
+	gotExpired, gotKept := splitExpiredParts([]*partWrapper{expiredButInMerge, expired, kept}, 500)
+
+	if len(gotExpired) != 1 || gotExpired[0] != expired {
+		t.Fatalf("splitExpiredParts() must not treat an in-merge part as expired; got expired=%v", gotExpired)
+	}
+	if len(gotKept) != 2 {
+		t.Fatalf("splitExpiredParts() must keep the in-merge part and the non-expired part; got kept=%v", gotKept)
+	}
+}
+
+func TestUnmarkInMergeClearsFlag(t *testing.T) {
+	pt := &partition{}
+	pw1 := newTestPartWrapperWithMaxTimestamp(100)
+	pw1.isInMerge = true
+	pw2 := newTestPartWrapperWithMaxTimestamp(200)
+	pw2.isInMerge = true
+
+	pt.unmarkInMerge([]*partWrapper{pw1, pw2})
+
+	if pw1.isInMerge || pw2.isInMerge {
+		t.Fatalf("unmarkInMerge() must clear isInMerge on every part passed to it")
+	}
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// rootPartInfo is the manifest entry for a single on-disk part.
+//
+// It mirrors the subset of partHeader needed to open the part without
+// re-deriving it from the part's own metadata file, and to reason about
+// staleness during garbage collection.
+type rootPartInfo struct {
+	PartName    string `json:"partName"`
+	IsBigPart   bool   `json:"isBigPart"`
+	MinTimestamp int64 `json:"minTimestamp"`
+	MaxTimestamp int64 `json:"maxTimestamp"`
+	RowsCount    uint64 `json:"rowsCount"`
+	BlocksCount  uint64 `json:"blocksCount"`
+}
+
+// rootSnapshot is an immutable, versioned description of the set of parts
+// that make up a partition at a given epoch.
+//
+// A completed merge and a retention drop each produce a new rootSnapshot and
+// persist it as root.<epoch>.json before atomically repointing the root.json
+// symlink, following bleve scorch's epoch+root scheme. addRowsPart doesn't
+// produce one: the in-memory parts it adds aren't listed in the manifest
+// until a merge writes them to disk.
+type rootSnapshot struct {
+	Epoch uint64         `json:"epoch"`
+	Parts []rootPartInfo `json:"parts"`
+}
+
+func rootManifestPath(path string, epoch uint64) string {
+	return fmt.Sprintf("%s/root.%016x.json", filepath.Clean(path), epoch)
+}
+
+func rootSymlinkPath(path string) string {
+	return filepath.Clean(path) + "/root.json"
+}
+
+// writeRootSnapshot persists snap as root.<epoch>.json under path and
+// atomically repoints the root.json symlink to it.
+func writeRootSnapshot(path string, snap *rootSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("cannot marshal root snapshot: %s", err)
+	}
+	manifestPath := rootManifestPath(path, snap.Epoch)
+	if err := fs.WriteFileAtomically(manifestPath, data); err != nil {
+		return fmt.Errorf("cannot write root manifest %q: %s", manifestPath, err)
+	}
+
+	symlinkPath := rootSymlinkPath(path)
+	tmpSymlinkPath := symlinkPath + ".tmp"
+	_ = os.Remove(tmpSymlinkPath)
+	if err := os.Symlink(filepath.Base(manifestPath), tmpSymlinkPath); err != nil {
+		return fmt.Errorf("cannot create temporary root symlink %q: %s", tmpSymlinkPath, err)
+	}
+	if err := os.Rename(tmpSymlinkPath, symlinkPath); err != nil {
+		return fmt.Errorf("cannot atomically swap root symlink %q: %s", symlinkPath, err)
+	}
+	fs.SyncPath(path)
+	return nil
+}
+
+// readRootSnapshot reads the rootSnapshot currently referenced by
+// root.json under path. It returns (nil, nil) if no manifest exists yet,
+// e.g. on a partition created before this feature or not yet flushed once.
+func readRootSnapshot(path string) (*rootSnapshot, error) {
+	symlinkPath := rootSymlinkPath(path)
+	data, err := os.ReadFile(symlinkPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot read root manifest %q: %s", symlinkPath, err)
+	}
+	var snap rootSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("cannot parse root manifest %q: %s", symlinkPath, err)
+	}
+	return &snap, nil
+}
+
+// nextEpoch atomically bumps and returns pt's epoch.
+func (pt *partition) nextEpoch() uint64 {
+	pt.epochLock.Lock()
+	pt.epoch++
+	e := pt.epoch
+	pt.epochLock.Unlock()
+	return e
+}
+
+// commitRootSnapshot builds a rootSnapshot from the current smallParts and
+// bigParts, persists it under pt.smallPartsPath (the canonical home for the
+// manifest, since it is the directory pt derives its name from), and
+// remembers it as the latest known snapshot for GC purposes.
+//
+// It is called after a merge completes and after a retention drop. It isn't
+// called from addRowsPart, since in-memory parts are left out of the manifest.
+//
+// Since the persisted manifest then accounts for every transaction applied
+// so far, this also truncates pt.txnLog: otherwise it keeps growing forever
+// and, on the next restart, runTransactionLog tries to re-apply merges whose
+// inputs and outputs a later merge has already superseded and deleted,
+// failing with "cannot find both source and destination paths".
+func (pt *partition) commitRootSnapshot() {
+	pt.partsLock.Lock()
+	parts := make([]rootPartInfo, 0, len(pt.smallParts)+len(pt.bigParts))
+	for _, pw := range pt.smallParts {
+		if pw.mp != nil {
+			// Inmemory parts aren't yet backed by a directory on disk.
+			continue
+		}
+		parts = append(parts, rootPartInfoFromHeader(pw.p.path, &pw.p.ph, false))
+	}
+	for _, pw := range pt.bigParts {
+		parts = append(parts, rootPartInfoFromHeader(pw.p.path, &pw.p.ph, true))
+	}
+	pt.partsLock.Unlock()
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartName < parts[j].PartName })
+
+	snap := &rootSnapshot{
+		Epoch: pt.nextEpoch(),
+		Parts: parts,
+	}
+	if err := writeRootSnapshot(pt.smallPartsPath, snap); err != nil {
+		logger.Panicf("FATAL: cannot commit root snapshot for partition %q: %s", pt.smallPartsPath, err)
+	}
+	if pt.txnLog != nil {
+		if err := pt.txnLog.Reset(); err != nil {
+			logger.Panicf("FATAL: cannot reset transaction log for partition %q: %s", pt.smallPartsPath, err)
+		}
+	}
+
+	pt.epochLock.Lock()
+	pt.liveSnapshots[snap.Epoch] = snap
+	pt.gcStaleEpochsLocked()
+	pt.epochLock.Unlock()
+}
+
+func rootPartInfoFromHeader(path string, ph *partHeader, isBig bool) rootPartInfo {
+	return rootPartInfo{
+		PartName:     filepath.Base(path),
+		IsBigPart:    isBig,
+		MinTimestamp: ph.MinTimestamp,
+		MaxTimestamp: ph.MaxTimestamp,
+		RowsCount:    ph.RowsCount,
+		BlocksCount:  ph.BlocksCount,
+	}
+}
+
+// Snapshot pins the current epoch so query engines can run a long scan
+// across a consistent view of parts without racing with merges or retention
+// drops that reclaim older epochs. Once the scan completes, the returned
+// epoch must be released with ReleaseSnapshot and the returned pws with PutParts.
+func (pt *partition) Snapshot() (epoch uint64, pws []*partWrapper) {
+	pws = pt.GetParts(nil)
+
+	pt.epochLock.Lock()
+	epoch = pt.epoch
+	pt.pinnedEpochs[epoch]++
+	pt.epochLock.Unlock()
+
+	return epoch, pws
+}
+
+// ReleaseSnapshot unpins the epoch obtained from Snapshot, allowing the
+// garbage collector to eventually remove parts that existed only in it.
+func (pt *partition) ReleaseSnapshot(epoch uint64) {
+	pt.epochLock.Lock()
+	defer pt.epochLock.Unlock()
+
+	n := pt.pinnedEpochs[epoch]
+	if n <= 1 {
+		delete(pt.pinnedEpochs, epoch)
+	} else {
+		pt.pinnedEpochs[epoch] = n - 1
+	}
+	pt.gcStaleEpochsLocked()
+}
+
+// gcStaleEpochsLocked drops remembered rootSnapshots older than the oldest
+// pinned (or current) epoch. It must be called with epochLock held.
+//
+// The actual directory garbage collection (deleting stray part directories
+// that belonged only to a stale epoch) is performed by gcStaleDirs, which a
+// caller should run periodically; here we only trim in-memory bookkeeping.
+func (pt *partition) gcStaleEpochsLocked() {
+	minEpoch := pt.epoch
+	for e := range pt.pinnedEpochs {
+		if e < minEpoch {
+			minEpoch = e
+		}
+	}
+	for e := range pt.liveSnapshots {
+		if e < minEpoch {
+			delete(pt.liveSnapshots, e)
+		}
+	}
+}
+
+// epochState holds the bookkeeping needed to support Snapshot/ReleaseSnapshot
+// and manifest-based garbage collection. It is embedded into partition.
+type epochState struct {
+	epochLock sync.Mutex
+
+	epoch uint64
+
+	// pinnedEpochs counts outstanding Snapshot() callers per epoch.
+	pinnedEpochs map[uint64]int
+
+	// liveSnapshots keeps the rootSnapshot for every epoch that is either
+	// current or still pinned, so gcStaleDirs knows which part directories
+	// are still referenced.
+	liveSnapshots map[uint64]*rootSnapshot
+}
+
+func newEpochState() epochState {
+	return epochState{
+		pinnedEpochs:  make(map[uint64]int),
+		liveSnapshots: make(map[uint64]*rootSnapshot),
+	}
+}
+
+// gcStaleDirs removes part directories under path that aren't referenced by
+// any live rootSnapshot. It is intentionally conservative: it only removes
+// directories that look like merge/retention leftovers (i.e. aren't named
+// "tmp", "txn", "wal" or currently referenced), so a partial write never
+// causes data loss.
+func (pt *partition) gcStaleDirs(path string) {
+	pt.epochLock.Lock()
+	referenced := make(map[string]bool)
+	for _, snap := range pt.liveSnapshots {
+		for _, p := range snap.Parts {
+			referenced[p.PartName] = true
+		}
+	}
+	pt.epochLock.Unlock()
+
+	d, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fs.MustClose(d)
+
+	fis, err := d.Readdir(-1)
+	if err != nil {
+		return
+	}
+	for _, fi := range fis {
+		if !fs.IsDirOrSymlink(fi) {
+			// Regular files such as txn.log, partition.json and
+			// merge_planner.json are never part directories.
+			continue
+		}
+		fn := fi.Name()
+		switch fn {
+		case "tmp", "txn", "wal", "snapshots":
+			continue
+		}
+		if len(fn) > 0 && fn[0] == '.' {
+			continue
+		}
+		if fn == filepath.Base(rootSymlinkPath(path)) || (len(fn) > 5 && fn[:5] == "root.") {
+			continue
+		}
+		if referenced[fn] {
+			continue
+		}
+		stray := filepath.Clean(path) + "/" + fn
+		logger.Infof("removing stray part directory %q not referenced by any live epoch", stray)
+		if err := os.RemoveAll(stray); err != nil {
+			logger.Errorf("cannot remove stray part directory %q: %s", stray, err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package storage
+
+import "testing"
+
+// TestOpenPartsFromManifestFiltersByTier guards against the root manifest
+// mix-up where snap (persisted once, covering both tiers) was opened against
+// whichever path the caller happened to pass without filtering entries by
+// IsBigPart first, crashing when the small-parts path tried to open a big
+// part's name and vice versa.
+func TestOpenPartsFromManifestFiltersByTier(t *testing.T) {
+	snap := &rootSnapshot{
+		Epoch: 1,
+		Parts: []rootPartInfo{
+			{PartName: "big-part-that-does-not-exist-on-disk", IsBigPart: true},
+		},
+	}
+
+	// Opening the small tier must skip the big-part entry entirely rather
+	// than attempting to open it (which would fail, since it only exists, if
+	// at all, under the big parts path).
+	pws, err := openPartsFromManifest("/nonexistent/small-parts-path", snap, false)
+	if err != nil {
+		t.Fatalf("openPartsFromManifest() for the small tier must ignore big-part entries, got error: %s", err)
+	}
+	if len(pws) != 0 {
+		t.Fatalf("openPartsFromManifest() for the small tier must not open any parts from a big-only manifest; got %d", len(pws))
+	}
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+// SnapshotManifest lists every part hardlinked into a partition snapshot
+// directory, together with a content hash over its on-disk files, so the
+// snapshot can be shipped to remote backup storage and later checked for
+// silent bitrot on whatever volume it ends up on.
+type SnapshotManifest struct {
+	Parts []SnapshotPartManifest `json:"parts"`
+}
+
+// SnapshotPartManifest describes a single part captured in a snapshot.
+type SnapshotPartManifest struct {
+	// Tier is "small" or "big".
+	Tier string `json:"tier"`
+
+	// Name is the part's directory name under the snapshot dir.
+	Name string `json:"name"`
+
+	// Hash is a hex-encoded SHA-256 over the name (followed by a NUL byte)
+	// and contents of every regular file in the part directory, in
+	// filename-sorted order (see hashPartDir), computed while the
+	// partition's snapshotLock was held.
+	Hash string `json:"hash"`
+
+	RowsCount   uint64 `json:"rowsCount"`
+	BlocksCount uint64 `json:"blocksCount"`
+
+	MinTimestamp int64 `json:"minTimestamp"`
+	MaxTimestamp int64 `json:"maxTimestamp"`
+}
+
+const snapshotManifestFilename = "snapshot_manifest.json"
+
+func snapshotManifestPath(snapshotDir string) string {
+	return filepath.Clean(snapshotDir) + "/" + snapshotManifestFilename
+}
+
+// writeSnapshotManifest persists m as snapshot_manifest.json under snapshotDir.
+func writeSnapshotManifest(snapshotDir string, m *SnapshotManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("cannot marshal snapshot manifest: %s", err)
+	}
+	path := snapshotManifestPath(snapshotDir)
+	if err := fs.WriteFileAtomically(path, data); err != nil {
+		return fmt.Errorf("cannot write %q: %s", path, err)
+	}
+	return nil
+}
+
+// readSnapshotManifest reads the snapshot_manifest.json under snapshotDir.
+func readSnapshotManifest(snapshotDir string) (*SnapshotManifest, error) {
+	path := snapshotManifestPath(snapshotDir)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %q: %s", path, err)
+	}
+	var m SnapshotManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %s", path, err)
+	}
+	return &m, nil
+}
+
+// hashPartDir computes a deterministic SHA-256 over every regular file in
+// partDir, streaming each file's contents through the hash in filename-sorted
+// order so the result doesn't depend on directory iteration order.
+func hashPartDir(partDir string) (string, error) {
+	entries, err := os.ReadDir(partDir)
+	if err != nil {
+		return "", fmt.Errorf("cannot list part directory %q: %s", partDir, err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		f, err := os.Open(partDir + "/" + name)
+		if err != nil {
+			return "", fmt.Errorf("cannot open %q/%q: %s", partDir, name, err)
+		}
+		fmt.Fprintf(h, "%s\x00", name)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("cannot hash %q/%q: %s", partDir, name, err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SnapshotPartDiagnostic reports the verification outcome for a single part
+// listed in a snapshot's manifest.
+type SnapshotPartDiagnostic struct {
+	Tier string `json:"tier"`
+	Name string `json:"name"`
+
+	// OK is true if the part's recomputed hash matches the manifest.
+	OK bool `json:"ok"`
+
+	// Error explains why OK is false: the part is missing, unreadable, or
+	// its recomputed hash doesn't match the manifest (bitrot).
+	Error string `json:"error,omitempty"`
+}
+
+// SnapshotVerifyReport is the result of VerifySnapshot.
+type SnapshotVerifyReport struct {
+	Parts []SnapshotPartDiagnostic `json:"parts"`
+}
+
+// AllOK returns true if every part in the report verified successfully.
+func (r *SnapshotVerifyReport) AllOK() bool {
+	for _, p := range r.Parts {
+		if !p.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifySnapshot reopens the manifest at snapshotDir (as written by
+// partition.createSnapshot), recomputes each listed part's content hash and
+// cross-checks it against the manifest, to detect silent bitrot on the
+// volume the snapshot lives on before it's shipped to backup storage.
+func VerifySnapshot(snapshotDir string) (*SnapshotVerifyReport, error) {
+	m, err := readSnapshotManifest(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SnapshotVerifyReport{}
+	for _, pm := range m.Parts {
+		diag := SnapshotPartDiagnostic{Tier: pm.Tier, Name: pm.Name}
+		hash, err := hashPartDir(filepath.Clean(snapshotDir) + "/" + pm.Name)
+		if err != nil {
+			diag.Error = err.Error()
+		} else if hash != pm.Hash {
+			diag.Error = fmt.Sprintf("content hash mismatch: manifest has %s, recomputed %s", pm.Hash, hash)
+		} else {
+			diag.OK = true
+		}
+		report.Parts = append(report.Parts, diag)
+	}
+	return report, nil
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"os"
+	"testing"
+)
+
+func TestVerifySnapshotDetectsBitrot(t *testing.T) {
+	snapshotDir := t.TempDir()
+	partDir := snapshotDir + "/small/part1"
+	if err := os.MkdirAll(partDir, 0755); err != nil {
+		t.Fatalf("cannot create part directory: %s", err)
+	}
+	if err := os.WriteFile(partDir+"/data.bin", []byte("original contents"), 0644); err != nil {
+		t.Fatalf("cannot write part file: %s", err)
+	}
+
+	hash, err := hashPartDir(partDir)
+	if err != nil {
+		t.Fatalf("hashPartDir() failed: %s", err)
+	}
+	m := &SnapshotManifest{
+		Parts: []SnapshotPartManifest{
+			{Tier: "small", Name: "small/part1", Hash: hash},
+		},
+	}
+	if err := writeSnapshotManifest(snapshotDir, m); err != nil {
+		t.Fatalf("writeSnapshotManifest() failed: %s", err)
+	}
+
+	report, err := VerifySnapshot(snapshotDir)
+	if err != nil {
+		t.Fatalf("VerifySnapshot() failed: %s", err)
+	}
+	if !report.AllOK() {
+		t.Fatalf("VerifySnapshot() must report OK for an untouched part; got %+v", report.Parts)
+	}
+
+	// Simulate bitrot: corrupt the file after the manifest was written.
+	if err := os.WriteFile(partDir+"/data.bin", []byte("corrupted contents"), 0644); err != nil {
+		t.Fatalf("cannot corrupt part file: %s", err)
+	}
+
+	report, err = VerifySnapshot(snapshotDir)
+	if err != nil {
+		t.Fatalf("VerifySnapshot() failed: %s", err)
+	}
+	if report.AllOK() {
+		t.Fatalf("VerifySnapshot() must detect a content hash mismatch after corruption")
+	}
+}
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// DisableWAL disables the write-ahead log for raw rows added via partition.AddRows.
+//
+// Disabling the WAL trades crash durability for ingestion throughput, since
+// rawRows accepted in AddRows are only protected by the in-memory buffer
+// until rawRowsFlushInterval or inmemoryPartsFlushInterval elapses.
+var DisableWAL = false
+
+// WALFlushInterval is the interval for flushing the buffered WAL writer to the underlying file.
+//
+// Smaller intervals reduce the amount of data lost on crash at the cost of more frequent writes.
+// These flushes don't fsync the file; only wal.MustClose does.
+var WALFlushInterval = 200 * time.Millisecond
+
+// defaultWALBufferedSize is the default number of rows buffered in memory
+// before they are flushed to the WAL file.
+const defaultWALBufferedSize = 4096
+
+// walRecordMagic marks the start of a single WAL record, so replayWAL can
+// distinguish a torn tail (partial write left by a crash) from a corrupted file.
+const walRecordMagic = 0xBADC0FFE
+
+// wal is a per-partition write-ahead log for rawRows.
+//
+// AddRows appends incoming rows to the wal before they become visible to
+// search, so they aren't lost if the process crashes before they are
+// durably converted into an on-disk part. Once that happens, the segment
+// covering those rows (see Rotate and walSeal) is removed.
+type wal struct {
+	path string
+
+	mu  sync.Mutex
+	f   *os.File
+	bw  *bufio.Writer
+	seq uint64
+
+	bufferedRows    int
+	maxBufferedRows int
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// walSeal references one or more sealed (rotated-out) WAL segments that
+// still hold the only durable copy of some rows until the on-disk parts made
+// from them are committed.
+//
+// A single AddRows/flushRawRows call may split its captured rows into
+// several in-memory parts (see partition.addRowsPart), so refCount tracks how
+// many of them still need to reach disk before the segments can be removed.
+type walSeal struct {
+	paths    []string
+	refCount int32
+}
+
+// release decrements ws's refCount and removes the sealed segments once it
+// reaches zero, i.e. once every part made from the rows they cover has been
+// durably written to disk. It is a no-op for a nil ws, so callers can attach
+// it unconditionally to parts that never came from the WAL.
+func (ws *walSeal) release() {
+	if ws == nil {
+		return
+	}
+	if atomic.AddInt32(&ws.refCount, -1) > 0 {
+		return
+	}
+	for _, path := range ws.paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("cannot remove sealed WAL segment %q: %s", path, err)
+		}
+	}
+}
+
+func openWAL(path string, maxBufferedRows int) (*wal, error) {
+	if maxBufferedRows <= 0 {
+		maxBufferedRows = defaultWALBufferedSize
+	}
+	if err := fs.MkdirAllIfNotExist(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("cannot create directory for WAL %q: %s", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open WAL file %q: %s", path, err)
+	}
+	w := &wal{
+		path:            path,
+		f:               f,
+		bw:              bufio.NewWriterSize(f, maxBufferedRows*64),
+		maxBufferedRows: maxBufferedRows,
+		stopCh:          make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.periodicFlusher()
+	}()
+	return w, nil
+}
+
+func (w *wal) periodicFlusher() {
+	t := time.NewTicker(WALFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-t.C:
+			w.mu.Lock()
+			if err := w.flushLocked(); err != nil {
+				logger.Panicf("FATAL: cannot flush WAL %q: %s", w.path, err)
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// Append appends rows to the wal.
+//
+// The rows become durable only after the next flush (either because
+// bufferedRows reached maxBufferedRows, WALFlushInterval elapsed, or MustClose
+// was called).
+func (w *wal) Append(rows []rawRow) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i := range rows {
+		if err := w.writeRecordLocked(&rows[i]); err != nil {
+			return fmt.Errorf("cannot append row to WAL %q: %s", w.path, err)
+		}
+		w.bufferedRows++
+	}
+	if w.bufferedRows >= w.maxBufferedRows {
+		if err := w.flushLocked(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *wal) writeRecordLocked(r *rawRow) error {
+	var buf []byte
+	buf = encoding.MarshalInt64(buf, r.Timestamp)
+	buf = encoding.MarshalUint64(buf, math.Float64bits(r.Value))
+	buf = encoding.MarshalUint64(buf, r.TSID.MetricGroupID)
+	buf = encoding.MarshalUint32(buf, r.TSID.JobID)
+	buf = encoding.MarshalUint32(buf, r.TSID.InstanceID)
+	buf = encoding.MarshalUint64(buf, r.TSID.MetricID)
+	buf = encoding.MarshalUint32(buf, uint32(r.PrecisionBits))
+
+	crc := crc32.ChecksumIEEE(buf)
+
+	var hdr [12]byte
+	binary.BigEndian.PutUint32(hdr[0:4], walRecordMagic)
+	binary.BigEndian.PutUint32(hdr[4:8], uint32(len(buf)))
+	binary.BigEndian.PutUint32(hdr[8:12], crc)
+
+	if _, err := w.bw.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.bw.Write(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (w *wal) flushLocked() error {
+	if w.bufferedRows == 0 {
+		return nil
+	}
+	if err := w.bw.Flush(); err != nil {
+		return fmt.Errorf("cannot flush buffered WAL writer: %s", err)
+	}
+	w.bufferedRows = 0
+	return nil
+}
+
+// Rotate seals off the wal's current segment by renaming it aside and
+// opening a fresh, empty segment for subsequent Append calls, returning the
+// path of the sealed-off segment.
+//
+// The caller must remove the returned path once the rows it contains have
+// been durably converted into an on-disk part (see walSeal.release);
+// until then it is replayed by replayWALSegments after a crash. Renaming
+// instead of truncating in place means an Append racing with a rotation
+// always lands cleanly in one segment or the other, never split across
+// both, and rows that are still only sitting in pt.rawRows at rotation time
+// stay protected by the sealed segment (see partition.rawRowsSeal) rather
+// than being discarded.
+func (w *wal) Rotate() (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flushLocked(); err != nil {
+		return "", err
+	}
+	if err := w.f.Close(); err != nil {
+		return "", fmt.Errorf("cannot close WAL %q before rotation: %s", w.path, err)
+	}
+
+	w.seq++
+	sealedPath := fmt.Sprintf("%s.%d", w.path, w.seq)
+	for fs.IsPathExist(sealedPath) {
+		// Never rename over a sealed segment left behind by an earlier run.
+		w.seq++
+		sealedPath = fmt.Sprintf("%s.%d", w.path, w.seq)
+	}
+	if err := os.Rename(w.path, sealedPath); err != nil {
+		return "", fmt.Errorf("cannot seal WAL segment %q: %s", w.path, err)
+	}
+
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return "", fmt.Errorf("cannot open new WAL segment %q: %s", w.path, err)
+	}
+	w.f = f
+	w.bw.Reset(f)
+	return sealedPath, nil
+}
+
+// MustClose flushes and fsyncs the wal, then closes the underlying file.
+func (w *wal) MustClose() {
+	close(w.stopCh)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flushLocked(); err != nil {
+		logger.Panicf("FATAL: cannot flush WAL %q on close: %s", w.path, err)
+	}
+	if err := w.f.Sync(); err != nil {
+		logger.Panicf("FATAL: cannot fsync WAL %q on close: %s", w.path, err)
+	}
+	if err := w.f.Close(); err != nil {
+		logger.Panicf("FATAL: cannot close WAL %q: %s", w.path, err)
+	}
+}
+
+// replayWAL reads all the rows stored in the WAL file at path.
+//
+// Torn tails (a partial record left behind by a crash mid-write) are skipped
+// instead of treated as fatal corruption, since they can only contain rows
+// which never got durably flushed to the app in the first place.
+func replayWAL(path string) ([]rawRow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot read WAL file %q: %s", path, err)
+	}
+
+	var rows []rawRow
+	for len(data) > 0 {
+		if len(data) < 12 {
+			// Torn header tail. Stop replay.
+			break
+		}
+		magic := binary.BigEndian.Uint32(data[0:4])
+		size := binary.BigEndian.Uint32(data[4:8])
+		crc := binary.BigEndian.Uint32(data[8:12])
+		data = data[12:]
+		if magic != walRecordMagic {
+			// Corrupted or torn record. Stop replay rather than treating it as fatal,
+			// since a crash can only have truncated the most recent record.
+			logger.Errorf("WAL %q: unexpected record magic; skipping the remaining tail", path)
+			break
+		}
+		if uint32(len(data)) < size {
+			// Torn record tail.
+			break
+		}
+		buf := data[:size]
+		data = data[size:]
+		if crc32.ChecksumIEEE(buf) != crc {
+			logger.Errorf("WAL %q: CRC mismatch for a record; skipping the remaining tail", path)
+			break
+		}
+
+		if len(buf) != 8+8+8+4+4+8+4 {
+			logger.Errorf("WAL %q: unexpected record size %d; skipping the remaining tail", path, len(buf))
+			break
+		}
+		var r rawRow
+		r.Timestamp = encoding.UnmarshalInt64(buf[0:8])
+		r.Value = math.Float64frombits(encoding.UnmarshalUint64(buf[8:16]))
+		r.TSID.MetricGroupID = encoding.UnmarshalUint64(buf[16:24])
+		r.TSID.JobID = encoding.UnmarshalUint32(buf[24:28])
+		r.TSID.InstanceID = encoding.UnmarshalUint32(buf[28:32])
+		r.TSID.MetricID = encoding.UnmarshalUint64(buf[32:40])
+		r.PrecisionBits = uint8(encoding.UnmarshalUint32(buf[40:44]))
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
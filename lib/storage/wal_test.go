@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWALAppendReplayRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.bin")
+	w, err := openWAL(path, 4)
+	if err != nil {
+		t.Fatalf("openWAL() failed: %s", err)
+	}
+
+	rows := []rawRow{
+		{
+			Timestamp: 1000,
+			Value:     -123.456,
+			TSID: TSID{
+				MetricGroupID: 1,
+				JobID:         2,
+				InstanceID:    3,
+				MetricID:      4,
+			},
+			PrecisionBits: 8,
+		},
+		{
+			Timestamp: 2000,
+			Value:     0,
+			TSID: TSID{
+				MetricGroupID: 5,
+				JobID:         6,
+				InstanceID:    7,
+				MetricID:      8,
+			},
+			PrecisionBits: 64,
+		},
+	}
+	if err := w.Append(rows); err != nil {
+		t.Fatalf("Append() failed: %s", err)
+	}
+	w.MustClose()
+
+	got, err := replayWAL(path)
+	if err != nil {
+		t.Fatalf("replayWAL() failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Fatalf("replayWAL() returned unexpected rows\ngot:  %+v\nwant: %+v", got, rows)
+	}
+}
+
+func TestReplayWALMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "does-not-exist.bin")
+	rows, err := replayWAL(path)
+	if err != nil {
+		t.Fatalf("replayWAL() on a missing file must not error: %s", err)
+	}
+	if len(rows) != 0 {
+		t.Fatalf("replayWAL() on a missing file must return no rows; got %d", len(rows))
+	}
+}